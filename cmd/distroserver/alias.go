@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LatestAlias is resolved automatically to the release with the highest semantic version unless it
+// is configured explicitly.
+const LatestAlias = "latest"
+
+// reservedAliases can't be used as alias names because they would shadow other routes.
+var reservedAliases = map[string]bool{"branch": true, "release": true, "status": true, "updatehook": true}
+
+// loadAliasFile reads a yaml file mapping alias names to version folders.  A missing file is not an error.
+func loadAliasFile(path string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return aliases, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, &aliases)
+	return aliases, err
+}
+
+// latestRelease returns the active release folder with the highest semantic version.
+func latestRelease(versions map[string]*distroVersion) string {
+	var latest string
+	var latestVersion *semver.Version
+	for p, v := range versions {
+		if v.handler == nil || !strings.HasPrefix(p, "release/") {
+			continue
+		}
+
+		version, err := semver.NewVersion(strings.TrimPrefix(p, "release/"))
+		if err != nil {
+			continue
+		}
+
+		if latestVersion == nil || version.GreaterThan(latestVersion) {
+			latest, latestVersion = p, version
+		}
+	}
+	return latest
+}
+
+// resolveAliases returns the aliases that point at active versions.  Aliases are read from the AliasFile
+// then overridden by Aliases.  The latest alias is added if it isn't configured.
+func (s *DistroServer) resolveAliases(versions map[string]*distroVersion) map[string]string {
+	configured := make(map[string]string)
+	if s.AliasFile != "" {
+		fileAliases, err := loadAliasFile(filepath.Join(s.repoPath, s.AliasFile))
+		if err != nil {
+			log.Printf("Unable to load alias file %s: %v", s.AliasFile, err)
+		}
+		for name, target := range fileAliases {
+			configured[name] = target
+		}
+	}
+
+	for name, target := range s.Aliases {
+		configured[name] = target
+	}
+
+	if _, ok := configured[LatestAlias]; !ok {
+		if latest := latestRelease(versions); latest != "" {
+			configured[LatestAlias] = latest
+		}
+	}
+
+	aliases := make(map[string]string)
+	for name, target := range configured {
+		name = strings.Trim(name, "/")
+		target = strings.Trim(filepath.Clean(target), "/")
+		if name == "" || strings.Contains(name, "/") || reservedAliases[name] {
+			log.Printf("Ignoring invalid alias name: %s", name)
+			continue
+		}
+
+		if v, ok := versions[target]; !ok || v.handler == nil {
+			log.Printf("Ignoring alias %s, %s isn't an active version", name, target)
+			continue
+		}
+		aliases[name] = target
+	}
+	return aliases
+}
+
+// aliasHandler serves requests for /<name>/... from the version folder at target.  The request path is
+// rewritten to the target folder so urls generated by templates refer to the resolved version.
+func aliasHandler(name, target string, h http.Handler) http.Handler {
+	prefix := "/" + name
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.Path = fmt.Sprintf("/%s%s", target, strings.TrimPrefix(r.URL.Path, prefix))
+		u.RawPath = ""
+		r2.URL = &u
+		h.ServeHTTP(w, r2)
+	})
+}
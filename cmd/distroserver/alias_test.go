@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestDistroServerAliases(t *testing.T) {
+	repoPath, err := ioutil.TempDir("", "distroserver")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(repoPath)
+
+	config := "endpoints:\n  static:\n    foo:\n      source: data\n"
+	for _, version := range []string{"branch/master", "release/v1.2.0", "release/v1.10.0", "release/not-semver"} {
+		folder := filepath.Join(repoPath, version)
+		writeTestDistro(t, folder, config)
+		os.MkdirAll(filepath.Join(folder, "data"), 0755)
+		ioutil.WriteFile(filepath.Join(folder, "data", "version.txt"), []byte(version), 0644)
+	}
+	aliasFile := "stable: release/v1.2.0\ndev: release/v1.2.0\nbogus: release/missing\nstatus: branch/master\n"
+	ioutil.WriteFile(filepath.Join(repoPath, "branch", "master", "aliases.yml"), []byte(aliasFile), 0644)
+
+	s := NewDistroServer(repoPath)
+	s.AliasFile = "branch/master/aliases.yml"
+	s.Aliases = map[string]string{"dev": "branch/master"}
+	if err := s.Rebuild(); err != nil {
+		t.Fatalf("unable to rebuild: %v", err)
+	}
+
+	for target, expected := range map[string]string{
+		"http://local/stable/foo/version.txt": "release/v1.2.0",
+		"http://local/latest/foo/version.txt": "release/v1.10.0",
+		"http://local/dev/foo/version.txt":    "branch/master",
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		body, _ := ioutil.ReadAll(w.Result().Body)
+		if w.Result().StatusCode != http.StatusOK || string(body) != expected {
+			t.Errorf("wrong response for %s: %d %s", target, w.Result().StatusCode, body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://local/bogus/foo/version.txt", nil))
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("alias to missing version returned status %d", w.Result().StatusCode)
+	}
+
+	expectedAliases := map[string]string{"stable": "release/v1.2.0", "latest": "release/v1.10.0", "dev": "branch/master"}
+	if diff := deep.Equal(s.Status().Aliases, expectedAliases); len(diff) > 0 {
+		t.Error("aliases don't match expected:")
+		for _, l := range diff {
+			t.Error(l)
+		}
+	}
+}
@@ -7,28 +7,79 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/PolarGeospatialCenter/pgcboot/pkg/distromux"
+	treebuilder "github.com/PolarGeospatialCenter/pgcboot/pkg/gittree"
 	"github.com/gorilla/mux"
 
 	"github.com/honeycombio/beeline-go/wrappers/hnygorilla"
 )
 
 type DistroServer struct {
-	repoPath    string
-	handlers    map[string]http.Handler
-	handlefuncs map[string]http.HandlerFunc
-	mu          sync.Mutex
+	// RunTests enables running each version's test suite before it is activated.  Release folders
+	// with failing tests are not activated, branch folders are activated with the failures reported.
+	RunTests bool
+	// Aliases maps alias names, served at /<name>/, to version folders such as release/v1.0.0.
+	Aliases map[string]string
+	// AliasFile is the path, relative to the repo path, of a yaml file containing additional aliases.
+	AliasFile string
+	// SnapshotPath, if set, is where a snapshot of each version folder is kept per commit.  Versions are loaded
+	// from their snapshot, so a last-known-good version keeps serving the files it was loaded with after its
+	// folder is replaced by a newer commit.  Snapshots hard link the files of the folder, so SnapshotPath should
+	// be on the same filesystem as the repo path to avoid copying them.
+	SnapshotPath string
+	repoPath     string
+	handlers     map[string]http.Handler
+	handlefuncs  map[string]http.HandlerFunc
+	versions     map[string]*distroVersion
+	aliases      map[string]string
+	lastRebuild  time.Time
+	syncErr      error
+	rebuildMu    sync.Mutex
+	mu           sync.Mutex
 	*mux.Router
 }
 
+// distroVersion tracks the last-known-good DistroMux loaded from a version folder along with
+// the error from the most recent attempt to load it, if any.  snapshot is set if the DistroMux was
+// loaded from a snapshot of the folder rather than the folder itself.
+type distroVersion struct {
+	handler     http.Handler
+	cfg         *distromux.DistroConfig
+	commit      string
+	snapshot    bool
+	loadedAt    time.Time
+	err         error
+	testResults map[string]*distromux.DistroTestResult
+}
+
+// failedTests returns the sorted paths of the failed test cases from the most recent load attempt.
+func (v *distroVersion) failedTests() []string {
+	failed := make([]string, 0)
+	for p, result := range v.testResults {
+		if result.Failed {
+			failed = append(failed, p)
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// Stale returns true if the most recent attempt to load this version failed.
+func (v *distroVersion) Stale() bool {
+	return v.err != nil
+}
+
 func NewDistroServer(repoPath string) *DistroServer {
 	var s DistroServer
 	s.repoPath = repoPath
 	s.handlers = make(map[string]http.Handler)
 	s.handlefuncs = make(map[string]http.HandlerFunc)
+	s.versions = make(map[string]*distroVersion)
 	s.Rebuild()
 	return &s
 }
@@ -67,26 +118,96 @@ func (s *DistroServer) getVersionFolders() ([]string, error) {
 	return result, nil
 }
 
+// loadVersion creates a router serving the DistroMux found in the version folder at path.  If
+// RunTests is set the test suite for the version is run and its results recorded.  The returned
+// distroVersion is never nil, though it will have no handler if an error is returned.
+func (s *DistroServer) loadVersion(path string, loadTime time.Time) (*distroVersion, error) {
+	srcpath := filepath.Join(s.repoPath, path)
+	commit := treebuilder.ReadCommit(srcpath)
+	loadPath, err := s.versionSource(path, commit)
+	if err != nil {
+		return &distroVersion{}, fmt.Errorf("unable to snapshot distro version at %s: %v", srcpath, err)
+	}
+
+	prefix := "/" + path + "/"
+	r := mux.NewRouter()
+	d, err := distromux.NewDistroMux(loadPath, r.PathPrefix(prefix).Subrouter())
+	if err != nil {
+		return &distroVersion{}, fmt.Errorf("unable to load distro version at %s: %v", srcpath, err)
+	}
+
+	v := &distroVersion{handler: r, cfg: d.Config(), commit: commit, snapshot: loadPath != srcpath, loadedAt: loadTime}
+	if !s.RunTests || !d.HasTests() {
+		return v, nil
+	}
+
+	results, err := d.Test()
+	if err != nil {
+		return &distroVersion{}, fmt.Errorf("unable to run tests for distro version at %s: %v", srcpath, err)
+	}
+	v.testResults = results
+
+	failed := v.failedTests()
+	if len(failed) > 0 {
+		log.Printf("%d test(s) failed for distro version at %s", len(failed), srcpath)
+		if strings.HasPrefix(path, "release/") {
+			return &distroVersion{testResults: results}, fmt.Errorf("refusing to activate release with %d failed test(s): %s", len(failed), strings.Join(failed, ", "))
+		}
+	}
+	return v, nil
+}
+
+// Rebuild loads each version folder independently.  Folders that fail to load continue to be served
+// by their last-known-good DistroMux, if any, and are reported as stale.  Without a SnapshotPath the
+// last-known-good DistroMux is only kept while the folder is at the same commit, since it serves files
+// from the folder.  Once every folder has been processed the router is swapped atomically.  An error
+// listing the folders that failed to load is returned.
 func (s *DistroServer) Rebuild() error {
+	s.rebuildMu.Lock()
+	defer s.rebuildMu.Unlock()
+
 	r := mux.NewRouter()
 	r.Use(TracePropagationMiddleware)
 	r.Use(hnygorilla.Middleware)
 	// Walk repoPath, adding a DistroMux for each directory Found
-	rebuildTime := time.Now().String()
+	rebuildTime := time.Now()
 	versionFolders, err := s.getVersionFolders()
 	if err != nil {
 		return err
 	}
 
+	versions := make(map[string]*distroVersion)
+	failed := make([]string, 0)
 	for _, path := range versionFolders {
-		srcpath := filepath.Join(s.repoPath, path)
-		prefix := "/" + path + "/"
-		_, err := distromux.NewDistroMux(srcpath, r.PathPrefix(prefix).Subrouter())
+		s.mu.Lock()
+		previous, ok := s.versions[path]
+		s.mu.Unlock()
+
+		v, err := s.loadVersion(path, rebuildTime)
 		if err != nil {
-			return fmt.Errorf("unable to load distro version at %s: %v", srcpath, err)
+			v.err = err
+			if ok && previous.handler != nil && (previous.snapshot || previous.commit == treebuilder.ReadCommit(filepath.Join(s.repoPath, path))) {
+				log.Printf("Continuing to serve last-known-good version of %s: %v", path, err)
+				v.handler, v.cfg, v.commit, v.snapshot, v.loadedAt = previous.handler, previous.cfg, previous.commit, previous.snapshot, previous.loadedAt
+			} else if ok && previous.handler != nil {
+				log.Printf("Not serving last-known-good version of %s, its folder has been replaced: %v", path, err)
+			}
+			failed = append(failed, fmt.Sprintf("%s: %v", path, err))
+		}
+		versions[path] = v
+	}
+
+	for path, v := range versions {
+		if v.handler != nil {
+			r.PathPrefix("/" + path + "/").Handler(v.handler)
 		}
 	}
 
+	aliases := s.resolveAliases(versions)
+	for name, target := range aliases {
+		r.PathPrefix("/" + name + "/").Handler(aliasHandler(name, target, versions[target].handler))
+	}
+
 	for p, h := range s.handlers {
 		r.Handle(p, h)
 	}
@@ -95,17 +216,96 @@ func (s *DistroServer) Rebuild() error {
 		r.HandleFunc(p, h)
 	}
 
-	r.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		statusString := fmt.Sprintf("Last updated at: %s", rebuildTime)
-		w.Write([]byte(statusString))
-	})
+	r.HandleFunc("/status", s.statusHandler)
+	r.HandleFunc("/status/tests", s.testStatusHandler)
+	r.HandleFunc("/status/versions", s.versionStatusHandler)
 
 	s.mu.Lock()
+	previous := s.versions
+	s.versions = versions
+	s.aliases = aliases
+	s.lastRebuild = rebuildTime
 	s.Router = r
 	s.mu.Unlock()
+
+	// the snapshots of the versions being replaced are kept until the next rebuild, requests may still be using them
+	s.pruneSnapshots(previous, versions)
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to load %d version folder(s): %s", len(failed), strings.Join(failed, "; "))
+	}
 	return nil
 }
 
+// SetSyncError records the error from the most recent attempt to update the version folders from git.  A nil
+// error clears the previous error.
+func (s *DistroServer) SetSyncError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncErr = err
+}
+
+// StaleVersions returns the version folders that failed to load during the last rebuild along with the
+// reason they failed.
+func (s *DistroServer) StaleVersions() map[string]error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stale := make(map[string]error)
+	for path, v := range s.versions {
+		if v.Stale() {
+			stale[path] = v.err
+		}
+	}
+	return stale
+}
+
+func (s *DistroServer) statusHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	statusString := fmt.Sprintf("Last updated at: %s\n", s.lastRebuild.String())
+	if s.syncErr != nil {
+		statusString += fmt.Sprintf("Sync error: %v\n", s.syncErr)
+	}
+	s.mu.Unlock()
+
+	stale := s.StaleVersions()
+	paths := make([]string, 0, len(stale))
+	for path := range stale {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		statusString += fmt.Sprintf("Stale: %s: %v\n", path, stale[path])
+	}
+	w.Write([]byte(statusString))
+}
+
+// testStatusHandler reports the output of every failed test from the last rebuild.
+func (s *DistroServer) testStatusHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := make([]string, 0, len(s.versions))
+	for path := range s.versions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	statusString := ""
+	for _, path := range paths {
+		v := s.versions[path]
+		if len(v.testResults) == 0 {
+			continue
+		}
+		failed := v.failedTests()
+		statusString += fmt.Sprintf("%s: %d of %d test(s) failed\n", path, len(failed), len(v.testResults))
+		for _, p := range failed {
+			statusString += fmt.Sprintf("--- FAIL: %s\n%s\n", p, v.testResults[p].Output)
+		}
+	}
+	w.Write([]byte(statusString))
+}
+
 func (s *DistroServer) Handle(path string, h http.Handler) {
 	s.handlers[path] = h
 	s.Router.Handle(path, h)
@@ -118,11 +318,11 @@ func (s *DistroServer) HandleFunc(path string, h http.HandlerFunc) {
 
 func (s *DistroServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Distroserver got request: %v", r)
-	if !s.Match(r, &mux.RouteMatch{}) {
-		log.Printf("No match found for request: %s", r.URL.Path)
-	}
 	s.mu.Lock()
 	root := s.Router
 	s.mu.Unlock()
+	if !root.Match(r, &mux.RouteMatch{}) {
+		log.Printf("No match found for request: %s", r.URL.Path)
+	}
 	root.ServeHTTP(w, r)
 }
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	gock "gopkg.in/h2non/gock.v1"
+
+	treebuilder "github.com/PolarGeospatialCenter/pgcboot/pkg/gittree"
 )
 
 func TestDistroServer(t *testing.T) {
@@ -34,3 +40,189 @@ func TestDistroServer(t *testing.T) {
 		t.Errorf("got wrong status from request: %d", w.Result().StatusCode)
 	}
 }
+
+func writeTestDistro(t *testing.T, folder, config string) {
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		t.Fatalf("unable to create distro folder: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(folder, "config.yml"), []byte(config), 0644); err != nil {
+		t.Fatalf("unable to write distro config: %v", err)
+	}
+}
+
+func TestDistroServerRebuildKeepsLastKnownGood(t *testing.T) {
+	repoPath, err := ioutil.TempDir("", "distroserver")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(repoPath)
+
+	goodConfig := "endpoints:\n  static:\n    foo:\n      source: data\n"
+	writeTestDistro(t, filepath.Join(repoPath, "branch", "master"), goodConfig)
+	writeTestDistro(t, filepath.Join(repoPath, "release", "v1.0.0"), goodConfig)
+	writeTestDistro(t, filepath.Join(repoPath, "branch", "broken"), "endpoints: [")
+
+	s := NewDistroServer(repoPath)
+	if err := s.Rebuild(); err == nil {
+		t.Errorf("expected error rebuilding with broken folder")
+	}
+
+	stale := s.StaleVersions()
+	if _, ok := stale["branch/broken"]; !ok || len(stale) != 1 {
+		t.Errorf("wrong stale versions reported: %v", stale)
+	}
+
+	checkStatus := func(target string, expected int) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Result().StatusCode != expected {
+			t.Errorf("got wrong status for %s: expected %d, got %d", target, expected, w.Result().StatusCode)
+		}
+	}
+
+	checkStatus("http://local/branch/master/health", http.StatusOK)
+	checkStatus("http://local/release/v1.0.0/health", http.StatusOK)
+	checkStatus("http://local/branch/broken/health", http.StatusNotFound)
+
+	// break a previously working folder, it should continue to be served
+	writeTestDistro(t, filepath.Join(repoPath, "branch", "master"), "endpoints: [")
+	s.Rebuild()
+
+	stale = s.StaleVersions()
+	if _, ok := stale["branch/master"]; !ok || len(stale) != 2 {
+		t.Errorf("wrong stale versions reported: %v", stale)
+	}
+	checkStatus("http://local/branch/master/health", http.StatusOK)
+	checkStatus("http://local/release/v1.0.0/health", http.StatusOK)
+
+	// removed folders are no longer served
+	os.RemoveAll(filepath.Join(repoPath, "release"))
+	s.Rebuild()
+	checkStatus("http://local/release/v1.0.0/health", http.StatusNotFound)
+}
+
+func TestDistroServerTestGate(t *testing.T) {
+	repoPath, err := ioutil.TempDir("", "distroserver")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(repoPath)
+
+	s := NewDistroServer(repoPath)
+	s.RunTests = true
+
+	config := "endpoints:\n  static:\n    foo:\n      source: data\n"
+	failingTest := "request:\n  path: /foo/test.txt\n  method: GET\nexpected:\n  status: 200\n  body: wrong\n"
+	for _, folder := range []string{filepath.Join(repoPath, "branch", "master"), filepath.Join(repoPath, "release", "v1.0.0")} {
+		writeTestDistro(t, folder, config)
+		os.MkdirAll(filepath.Join(folder, "data"), 0755)
+		os.MkdirAll(filepath.Join(folder, "tests"), 0755)
+		ioutil.WriteFile(filepath.Join(folder, "data", "test.txt"), []byte("right"), 0644)
+		ioutil.WriteFile(filepath.Join(folder, "tests", "test.yml"), []byte(failingTest), 0644)
+	}
+
+	if err := s.Rebuild(); err == nil {
+		t.Errorf("expected error rebuilding with failing release tests")
+	}
+
+	stale := s.StaleVersions()
+	if _, ok := stale["release/v1.0.0"]; !ok || len(stale) != 1 {
+		t.Errorf("wrong stale versions reported: %v", stale)
+	}
+
+	for target, expected := range map[string]int{
+		"http://local/branch/master/foo/test.txt":  http.StatusOK,
+		"http://local/release/v1.0.0/foo/test.txt": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Result().StatusCode != expected {
+			t.Errorf("got wrong status for %s: expected %d, got %d", target, expected, w.Result().StatusCode)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://local/status/tests", nil))
+	body, _ := ioutil.ReadAll(w.Result().Body)
+	for _, expected := range []string{"branch/master: 1 of 1 test(s) failed", "release/v1.0.0: 1 of 1 test(s) failed", "--- FAIL:"} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("test status doesn't contain '%s':\n%s", expected, body)
+		}
+	}
+}
+
+func TestDistroServerRebuildSnapshots(t *testing.T) {
+	repoPath, err := ioutil.TempDir("", "distroserver")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(repoPath)
+
+	snapshotPath, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(snapshotPath)
+
+	writeVersion := func(folder, commit, config, data string) {
+		writeTestDistro(t, folder, config)
+		os.MkdirAll(filepath.Join(folder, "data"), 0755)
+		ioutil.WriteFile(filepath.Join(folder, "data", "test.txt"), []byte(data), 0644)
+		ioutil.WriteFile(filepath.Join(folder, treebuilder.CommitFile), []byte(commit+"\n"), 0644)
+	}
+
+	checkBody := func(s *DistroServer, target string, expectedStatus int, expectedBody string) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		body, _ := ioutil.ReadAll(w.Result().Body)
+		if w.Result().StatusCode != expectedStatus || (expectedBody != "" && string(body) != expectedBody) {
+			t.Errorf("got wrong response for %s: %d %q", target, w.Result().StatusCode, body)
+		}
+	}
+
+	goodConfig := "endpoints:\n  static:\n    foo:\n      source: data\n"
+	master := filepath.Join(repoPath, "branch", "master")
+	writeVersion(master, "aaaa", goodConfig, "old")
+
+	s := NewDistroServer(repoPath)
+	s.SnapshotPath = snapshotPath
+	if err := s.Rebuild(); err != nil {
+		t.Fatalf("unable to rebuild: %v", err)
+	}
+	checkBody(s, "http://local/branch/master/foo/test.txt", http.StatusOK, "old")
+
+	original, err := os.Stat(filepath.Join(master, "data", "test.txt"))
+	if err != nil {
+		t.Fatalf("unable to stat test file: %v", err)
+	}
+	snapshot, err := os.Stat(filepath.Join(snapshotPath, "aaaa", "data", "test.txt"))
+	if err != nil || !os.SameFile(original, snapshot) {
+		t.Errorf("snapshot file isn't a hard link to the version folder: %v", err)
+	}
+
+	// a new commit that fails to load, the last-known-good version keeps serving its own files
+	os.RemoveAll(master)
+	writeVersion(master, "bbbb", "endpoints: [", "new")
+	if err := s.Rebuild(); err == nil {
+		t.Errorf("expected an error rebuilding with a broken folder")
+	}
+	checkBody(s, "http://local/branch/master/foo/test.txt", http.StatusOK, "old")
+
+	if _, err := os.Stat(filepath.Join(snapshotPath, "aaaa")); err != nil {
+		t.Errorf("snapshot of the last-known-good version removed: %v", err)
+	}
+
+	// without snapshots the last-known-good version is dropped once its folder is replaced
+	s = NewDistroServer(repoPath)
+	os.RemoveAll(master)
+	writeVersion(master, "cccc", goodConfig, "old")
+	s.Rebuild()
+	checkBody(s, "http://local/branch/master/foo/test.txt", http.StatusOK, "old")
+
+	os.RemoveAll(master)
+	writeVersion(master, "dddd", "endpoints: [", "new")
+	s.Rebuild()
+	checkBody(s, "http://local/branch/master/foo/test.txt", http.StatusNotFound, "")
+}
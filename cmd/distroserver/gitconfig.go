@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+
+	treebuilder "github.com/PolarGeospatialCenter/pgcboot/pkg/gittree"
+)
+
+// configGetter is satisfied by the config store used by main.
+type configGetter interface {
+	GetString(string) string
+	GetStringSlice(string) []string
+	GetInt(string) int
+}
+
+// refFilter builds the branch and tag filter from the git.branches and git.tags config sections.
+func refFilter(cfg configGetter) *treebuilder.RefFilter {
+	return &treebuilder.RefFilter{
+		IncludeBranches: cfg.GetStringSlice("git.branches.include"),
+		ExcludeBranches: cfg.GetStringSlice("git.branches.exclude"),
+		IncludeTags:     cfg.GetStringSlice("git.tags.include"),
+		ExcludeTags:     cfg.GetStringSlice("git.tags.exclude"),
+		MaxReleases:     cfg.GetInt("git.tags.max"),
+	}
+}
+
+// newTreeBuilder creates a git tree builder, filtering branches and tags as described by refFilter, using the
+// transport selected by git.transport:
+//
+//	ssh   - authenticates with the private key in git.deploykey (default), verifying the host key
+//	        against git.known_hosts and/or git.host_key_fingerprints when configured
+//	https - authenticates with git.username and git.password, or git.token if set
+//	local - unauthenticated access to a local path or file:// url
+func newTreeBuilder(cfg configGetter, treePath, repoPath string) (*treebuilder.Builder, error) {
+	b, err := newTransportBuilder(cfg, treePath, repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.SetRefFilter(refFilter(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func newTransportBuilder(cfg configGetter, treePath, repoPath string) (*treebuilder.Builder, error) {
+	repoURL := cfg.GetString("git.repourl")
+	if repoURL == "" {
+		return nil, fmt.Errorf("no repository url configured")
+	}
+
+	switch cfg.GetString("git.transport") {
+	case "ssh", "":
+		deployKey := cfg.GetString("git.deploykey")
+		if deployKey == "" {
+			return nil, fmt.Errorf("got empty deploy key, error retrieving?")
+		}
+		b, err := treebuilder.NewSSHBuilder(repoURL, deployKey, treePath, repoPath)
+		if err != nil {
+			return nil, err
+		}
+
+		knownHosts, fingerprints := cfg.GetStringSlice("git.known_hosts"), cfg.GetStringSlice("git.host_key_fingerprints")
+		if len(knownHosts) > 0 || len(fingerprints) > 0 {
+			err = b.SetSSHHostKeys(knownHosts, fingerprints)
+		}
+		return b, err
+	case "https":
+		username, password := cfg.GetString("git.username"), cfg.GetString("git.password")
+		if token := cfg.GetString("git.token"); token != "" {
+			password = token
+			if username == "" {
+				username = "git"
+			}
+		}
+		return treebuilder.NewHTTPSBuilder(repoURL, username, password, treePath, repoPath)
+	case "local":
+		return treebuilder.NewBuilder(repoURL, nil, treePath, repoPath)
+	default:
+		return nil, fmt.Errorf("unsupported git transport: %s", cfg.GetString("git.transport"))
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type mapConfig map[string]string
+
+func (c mapConfig) GetString(key string) string {
+	return c[key]
+}
+
+func (c mapConfig) GetStringSlice(key string) []string {
+	return strings.Fields(c[key])
+}
+
+func (c mapConfig) GetInt(key string) int {
+	i, _ := strconv.Atoi(c[key])
+	return i
+}
+
+func TestNewTreeBuilderLocal(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "distroserver")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	repoURL, _ := filepath.Abs(path.Join("..", "..", "test", "repo"))
+	cfg := mapConfig{"git.transport": "local", "git.repourl": repoURL, "git.branches.exclude": "test*"}
+	b, err := newTreeBuilder(cfg, path.Join(tempDir, "tree"), path.Join(tempDir, "repo"))
+	if err != nil {
+		t.Fatalf("unable to create local tree builder: %v", err)
+	}
+
+	if err := b.BuildGitTree(); err != nil {
+		t.Errorf("unable to build git tree: %v", err)
+	}
+
+	for folder, expected := range map[string]bool{"branch/master": true, "branch/testbranch": false, "release/v0.0.1": true} {
+		_, err := os.Stat(path.Join(tempDir, "tree", folder))
+		if exists := err == nil; exists != expected {
+			t.Errorf("unexpected state for %s: expected exists = %t", folder, expected)
+		}
+	}
+}
+
+func testDeployKey(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate deploy key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+func TestNewTreeBuilderErrors(t *testing.T) {
+	cases := map[string]mapConfig{
+		"missing url":        mapConfig{"git.transport": "local"},
+		"missing deploy key": mapConfig{"git.repourl": "git@github.com:PolarGeospatialCenter/pgcboot.git"},
+		"bad https url":      mapConfig{"git.transport": "https", "git.repourl": "git@github.com:PolarGeospatialCenter/pgcboot.git", "git.token": "token"},
+		"unknown transport":  mapConfig{"git.transport": "ftp", "git.repourl": "ftp://example.com/repo.git"},
+		"bad ref filter":     mapConfig{"git.transport": "local", "git.repourl": "/tmp/repo", "git.tags.include": "regex:("},
+		"missing known_hosts": mapConfig{
+			"git.repourl":     "git@github.com:PolarGeospatialCenter/pgcboot.git",
+			"git.deploykey":   testDeployKey(t),
+			"git.known_hosts": "/nonexistent/known_hosts",
+		},
+	}
+
+	for name, cfg := range cases {
+		if _, err := newTreeBuilder(cfg, "", ""); err == nil {
+			t.Errorf("%s: expected error creating tree builder", name)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -12,7 +13,7 @@ import (
 	"time"
 
 	"github.com/PolarGeospatialCenter/awstools/pkg/config"
-	treebuilder "github.com/PolarGeospatialCenter/pgcboot/pkg/gittree"
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
 	"github.com/gorilla/mux"
 	"github.com/honeycombio/beeline-go"
 	"gopkg.in/go-playground/webhooks.v3"
@@ -26,6 +27,7 @@ func main() {
 	cfg.AddConfigPath("/etc/distroserver")
 	cfg.AddConfigPath(".")
 	cfg.SetDefault("tempdir", "")
+	cfg.SetDefault("tests.gate", false)
 	// load config
 	cfg.ReadInConfig()
 
@@ -41,6 +43,9 @@ func main() {
 		})
 	}
 
+	// consul datasources without their own address or token use these
+	api.SetConsulDefaults(cfg.GetString("consul.address"), cfg.GetString("consul.token"))
+
 	// Create temporary path for repository
 	repoPath, err := ioutil.TempDir(cfg.GetString("tempdir"), "repository")
 	if err != nil {
@@ -57,25 +62,36 @@ func main() {
 	defer os.RemoveAll(treePath)
 	log.Printf("Working tree path: %s", treePath)
 
+	// Create directory to store snapshots of the work trees being served
+	snapshotPath, err := ioutil.TempDir(cfg.GetString("tempdir"), "snapshots")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(snapshotPath)
+
 	server := NewDistroServer(treePath)
+	server.SnapshotPath = snapshotPath
+	server.RunTests = cfg.GetBool("tests.gate")
+	server.Aliases = cfg.GetStringMapString("aliases.static")
+	server.AliasFile = cfg.GetString("aliases.file")
 
 	updateFunc := func(_ interface{}, _ webhooks.Header) {
-		deployKey := cfg.GetString("git.deploykey")
-		if deployKey == "" {
-			log.Fatalf("Got empty deploy key, error retrieving?")
-		}
+		log.Printf("Using RepoURL: %s", cfg.GetString("git.repourl"))
 
-		repoUrl := cfg.GetString("git.repourl")
-		log.Printf("Using RepoURL: %s", repoUrl)
-
-		builder, err := treebuilder.NewSSHBuilder(repoUrl, deployKey, treePath, repoPath)
+		builder, err := newTreeBuilder(cfg, treePath, repoPath)
 		if err != nil {
-			log.Fatalf("Unable to create git tree builder: %v", err)
+			log.Printf("Unable to create git tree builder: %v", err)
+			server.SetSyncError(fmt.Errorf("unable to create git tree builder: %v", err))
+			return
 		}
 
+		// refs that failed to check out keep their previous folders, so rebuild with whatever was updated
 		err = builder.BuildGitTree()
 		if err != nil {
-			log.Fatalf("Unable to build git tree: %v", err)
+			log.Printf("Unable to build git tree: %v", err)
+			server.SetSyncError(fmt.Errorf("unable to build git tree: %v", err))
+		} else {
+			server.SetSyncError(nil)
 		}
 
 		err = server.Rebuild()
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// linkTree recreates the directory src at dst, preserving file modes and symlinks.  Files are hard linked
+// rather than copied, falling back to a copy if that isn't possible, for example when dst is on another
+// filesystem.  Checkouts are replaced as a whole rather than changed in place, so linked files never change.
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// versionSource returns the folder the version at path should be loaded from.  If SnapshotPath is set and the
+// folder records the commit it was checked out from, a snapshot of the folder is made for the commit, unless
+// one already exists, and its path is returned.  Otherwise the version folder itself is used.
+func (s *DistroServer) versionSource(path, commit string) (string, error) {
+	srcpath := filepath.Join(s.repoPath, path)
+	if s.SnapshotPath == "" || commit == "" {
+		return srcpath, nil
+	}
+
+	snapshot := filepath.Join(s.SnapshotPath, commit)
+	if _, err := os.Stat(snapshot); err == nil {
+		return snapshot, nil
+	}
+
+	if err := os.MkdirAll(s.SnapshotPath, 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempDir(s.SnapshotPath, ".snapshot")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := linkTree(srcpath, filepath.Join(tmp, "tree")); err != nil {
+		return "", fmt.Errorf("unable to snapshot %s: %v", srcpath, err)
+	}
+
+	if err := os.Rename(filepath.Join(tmp, "tree"), snapshot); err != nil {
+		return "", err
+	}
+	return snapshot, nil
+}
+
+// pruneSnapshots removes the snapshots that aren't used by any of the sets of versions.
+func (s *DistroServer) pruneSnapshots(versionSets ...map[string]*distroVersion) {
+	if s.SnapshotPath == "" {
+		return
+	}
+
+	used := make(map[string]bool)
+	for _, versions := range versionSets {
+		for _, v := range versions {
+			if v.snapshot {
+				used[v.commit] = true
+			}
+		}
+	}
+
+	folders, err := ioutil.ReadDir(s.SnapshotPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to list snapshots: %v", err)
+		}
+		return
+	}
+
+	for _, f := range folders {
+		if used[f.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.SnapshotPath, f.Name())); err != nil {
+			log.Printf("Unable to remove snapshot %s: %v", f.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/distromux"
+)
+
+// ServerStatus describes everything currently being served by a DistroServer.
+type ServerStatus struct {
+	LastRebuild time.Time         `json:"last_rebuild"`
+	SyncError   string            `json:"sync_error,omitempty"`
+	Aliases     map[string]string `json:"aliases,omitempty"`
+	Versions    []*VersionStatus  `json:"versions"`
+}
+
+// VersionStatus describes a single branch or release folder.
+type VersionStatus struct {
+	Path        string                       `json:"path"`
+	Type        string                       `json:"type"`
+	Name        string                       `json:"name"`
+	Active      bool                         `json:"active"`
+	Stale       bool                         `json:"stale"`
+	Commit      string                       `json:"commit,omitempty"`
+	LoadedAt    *time.Time                   `json:"loaded_at,omitempty"`
+	Error       string                       `json:"error,omitempty"`
+	Endpoints   map[string][]string          `json:"endpoints,omitempty"`
+	DataSources map[string]*DataSourceStatus `json:"datasources,omitempty"`
+	DistroVars  interface{}                  `json:"vars,omitempty"`
+	Tests       *TestStatus                  `json:"tests,omitempty"`
+}
+
+// DataSourceStatus describes a datasource configured for a version.  Only the unrendered URL, without
+// userinfo or query, is reported so that secrets supplied through the environment or in the URL itself
+// aren't exposed.
+type DataSourceStatus struct {
+	Type   string `json:"type,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Method string `json:"method,omitempty"`
+	Auth   string `json:"auth,omitempty"`
+}
+
+// TestStatus summarizes the results of the most recent test run for a version.
+type TestStatus struct {
+	Total  int               `json:"total"`
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+func endpointPaths(cfg *distromux.DistroConfig) map[string][]string {
+	endpoints := make(map[string][]string)
+	add := func(kind string, p string) {
+		endpoints[kind] = append(endpoints[kind], path.Clean("/"+p))
+	}
+
+	for p := range cfg.Endpoints.Template {
+		add("template", p)
+	}
+	for p := range cfg.Endpoints.Static {
+		add("static", p)
+	}
+	for p := range cfg.Endpoints.Proxy {
+		add("proxy", p)
+	}
+	for p := range cfg.Endpoints.IPXE {
+		add("ipxe", p)
+	}
+
+	for kind := range endpoints {
+		sort.Strings(endpoints[kind])
+	}
+	return endpoints
+}
+
+func (v *distroVersion) status(versionPath string) *VersionStatus {
+	status := &VersionStatus{
+		Path:   versionPath,
+		Active: v.handler != nil,
+		Stale:  v.Stale(),
+		Commit: v.commit,
+	}
+
+	if parts := strings.SplitN(versionPath, "/", 2); len(parts) == 2 {
+		status.Type, status.Name = parts[0], parts[1]
+	}
+
+	if v.err != nil {
+		status.Error = v.err.Error()
+	}
+
+	if !v.loadedAt.IsZero() {
+		loadedAt := v.loadedAt
+		status.LoadedAt = &loadedAt
+	}
+
+	if v.cfg != nil {
+		status.Endpoints = endpointPaths(v.cfg)
+		status.DistroVars = api.NormalizeYAML(map[string]interface{}(v.cfg.DistroVars))
+		status.DataSources = make(map[string]*DataSourceStatus)
+		for name, ds := range v.cfg.DataSources {
+			status.DataSources[name] = &DataSourceStatus{Type: ds.Type, URL: api.RedactURL(ds.URL), Path: ds.Path, Key: ds.Key, Method: ds.Method, Auth: ds.Auth}
+		}
+	}
+
+	if len(v.testResults) > 0 {
+		status.Tests = &TestStatus{Total: len(v.testResults), Failed: make(map[string]string)}
+		for _, p := range v.failedTests() {
+			status.Tests.Failed[p] = v.testResults[p].Output
+		}
+	}
+	return status
+}
+
+// Status returns the status of every version folder found during the last rebuild.
+func (s *DistroServer) Status() *ServerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := &ServerStatus{LastRebuild: s.lastRebuild, Versions: make([]*VersionStatus, 0, len(s.versions))}
+	if s.syncErr != nil {
+		status.SyncError = s.syncErr.Error()
+	}
+	status.Aliases = make(map[string]string, len(s.aliases))
+	for name, target := range s.aliases {
+		status.Aliases[name] = target
+	}
+	for p, v := range s.versions {
+		status.Versions = append(status.Versions, v.status(p))
+	}
+	sort.Slice(status.Versions, func(i, j int) bool { return status.Versions[i].Path < status.Versions[j].Path })
+	return status
+}
+
+// versionStatusHandler writes the server status as JSON.
+func (s *DistroServer) versionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.Status()); err != nil {
+		log.Printf("Unable to write status to client: %v", err)
+	}
+}
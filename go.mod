@@ -1,17 +1,17 @@
 module github.com/PolarGeospatialCenter/pgcboot
 
-go 1.12
+go 1.13
 
 require (
 	github.com/BurntSushi/toml v0.3.1 // indirect
 	github.com/Masterminds/goutils v1.1.0 // indirect
-	github.com/Masterminds/semver v1.4.2 // indirect
+	github.com/Masterminds/semver v1.4.2
 	github.com/Masterminds/sprig v2.18.0+incompatible
 	github.com/PolarGeospatialCenter/awstools v0.0.0-20180520175615-dbe1840ac783
 	github.com/PolarGeospatialCenter/dockertest v0.0.0-20190402172603-7e70c31421a4 // indirect
 	github.com/PolarGeospatialCenter/inventory v0.3.0 // indirect
 	github.com/alcortesm/tgz v0.0.0-20161220082320-9c5fe88206d7 // indirect
-	github.com/anmitsu/go-shlex v0.0.0-20161002113705-648efa622239 // indirect
+	github.com/anmitsu/go-shlex v0.0.0-20161002113705-648efa622239
 	github.com/aws/aws-lambda-go v1.11.1 // indirect
 	github.com/aws/aws-sdk-go v1.13.52
 	github.com/azenk/iputils v0.0.0-20180901170612-d1883c0677d3 // indirect
@@ -47,7 +47,7 @@ require (
 	github.com/magiconair/properties v1.7.6 // indirect
 	github.com/manifoldco/promptui v0.3.2 // indirect
 	github.com/mitchellh/go-homedir v0.0.0-20161203194507-b8bc1bf76747 // indirect
-	github.com/mitchellh/mapstructure v0.0.0-20180220230111-00c29f56e238 // indirect
+	github.com/mitchellh/mapstructure v0.0.0-20180220230111-00c29f56e238
 	github.com/nbio/st v0.0.0-20140626010706-e9e8d9816f32 // indirect
 	github.com/opencontainers/go-digest v1.0.0-rc1 // indirect
 	github.com/pelletier/go-buffruneio v0.2.0 // indirect
@@ -63,6 +63,7 @@ require (
 	github.com/src-d/gcfg v1.3.0 // indirect
 	github.com/stretchr/testify v1.3.0 // indirect
 	github.com/xanzy/ssh-agent v0.1.0 // indirect
+	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 	gopkg.in/alexcesaro/statsd.v2 v2.0.0 // indirect
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/go-playground/webhooks.v3 v3.10.0
@@ -73,5 +74,5 @@ require (
 	gopkg.in/src-d/go-git-fixtures.v3 v3.5.0 // indirect
 	gopkg.in/src-d/go-git.v4 v4.2.1
 	gopkg.in/warnings.v0 v0.1.2 // indirect
-	gopkg.in/yaml.v2 v2.2.1 // indirect
+	gopkg.in/yaml.v2 v2.2.1
 )
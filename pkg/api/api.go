@@ -2,7 +2,7 @@ package api
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,25 +10,107 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	iamsign "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/honeycombio/beeline-go/propagation"
+	"github.com/honeycombio/beeline-go/trace"
 )
 
+// DefaultAWSService is the service requests are signed for when using iam auth if no service is configured.
+const DefaultAWSService = "execute-api"
+
+// AWSConfig configures how requests are signed when using iam auth.  Service and Region select the
+// SigV4 credential scope, defaulting to execute-api and the region of the default session.  If RoleARN
+// is set the role is assumed and its temporary credentials are used to sign requests.
+type AWSConfig struct {
+	Service string `mapstructure:"service"`
+	Region  string `mapstructure:"region"`
+	RoleARN string `mapstructure:"role_arn"`
+}
+
 // APIResponse is a data structure encapsulating the return from an api endpoint
 type APIResponse struct {
-	Status int
-	Data   interface{}
+	Status   int
+	Headers  http.Header
+	Data     interface{}
+	Cached   bool
+	attempts int
+}
+
+// RetryPolicy describes how failed calls to an Endpoint are retried.  Calls that fail with a
+// connection error or a 5xx status are retried up to Attempts times in total, waiting Backoff
+// before the first retry and doubling the wait for each subsequent retry up to MaxBackoff.  Only
+// GET, HEAD and OPTIONS requests are retried unless AllMethods is set, so that a request with side
+// effects isn't repeated.
+type RetryPolicy struct {
+	Attempts   int           `mapstructure:"attempts"`
+	Backoff    time.Duration `mapstructure:"backoff"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+	AllMethods bool          `mapstructure:"all_methods"`
+}
+
+// CachePolicy describes how successful responses from an Endpoint are cached.  Responses are cached
+// for TTL, keyed on the method, url and request body.  A zero TTL disables caching.
+type CachePolicy struct {
+	TTL time.Duration `mapstructure:"ttl"`
 }
 
-// Endpoint is a single API endpoint/resource
+// Endpoint is a single API endpoint/resource.  Type selects the Backend used to fetch data, defaulting to
+// http.  Auth selects how requests are authenticated:
+//
+//	iam    - requests are signed with AWS SigV4 as configured by AWS
+//	bearer - Credentials.Token is sent as a bearer token
+//	basic  - Credentials.Username and Credentials.Password are sent using HTTP basic auth
+//	mtls   - the client certificate configured in TLS is presented to the server
+//
+// TLS.CA may be used with any auth mode to verify servers using a private CA.
+//
+// Format selects how the response body is decoded into Data, see the Format constants.  Responses are
+// decoded as JSON by default.  If Schema (an inline JSON or YAML document) or SchemaFile is set successful
+// responses are validated against the JSON Schema, and calls fail with a *SchemaError if they don't match.
+// Schema is a string rather than a map because config keys are case insensitive.
 type Endpoint struct {
-	URL        string `mapstructure:"url"`
-	Method     string `mapstructure:"method"`
-	Auth       string `mapstructure:"auth"`
-	iamSession *session.Session
+	URL             string        `mapstructure:"url"`
+	Method          string        `mapstructure:"method"`
+	Auth            string        `mapstructure:"auth"`
+	Type            string        `mapstructure:"type"`
+	Path            string        `mapstructure:"path"`
+	Key             string        `mapstructure:"key"`
+	Format          string        `mapstructure:"format"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	Retry           RetryPolicy   `mapstructure:"retry"`
+	Cache           CachePolicy   `mapstructure:"cache"`
+	Credentials     Credentials   `mapstructure:"credentials"`
+	TLS             TLSConfig     `mapstructure:"tls"`
+	AWS             AWSConfig     `mapstructure:"aws"`
+	Schema          string        `mapstructure:"schema"`
+	SchemaFile      string        `mapstructure:"schema_file"`
+	iamMu           sync.Mutex
+	iamSession      *session.Session
+	roleCredentials *credentials.Credentials
+	transport       http.RoundTripper
+	tlsMu           sync.Mutex
+	tlsTransport    *http.Transport
+	cache           responseCache
+	basePath        string
+	backendMu       sync.Mutex
+	backend         Backend
+	schemaMu        sync.Mutex
+	compiledSchema  *Schema
+}
+
+// SetTransport overrides the http.RoundTripper used to call the endpoint.  A nil transport
+// uses http.DefaultTransport.
+func (e *Endpoint) SetTransport(rt http.RoundTripper) {
+	e.transport = rt
 }
 
 func (e *Endpoint) GetUrl(subPath, query string) (*url.URL, error) {
@@ -54,50 +136,214 @@ func (e *Endpoint) GetUrl(subPath, query string) (*url.URL, error) {
 
 // Call the Endpoint with the provided query string and requestBody (if applicable)
 func (e *Endpoint) Call(subPath, query, requestBody string) (*APIResponse, error) {
+	return e.CallContext(context.Background(), subPath, query, requestBody)
+}
+
+// CallContext calls the Endpoint with the provided query string and requestBody (if applicable).  Outstanding
+// requests and retries are abandoned when ctx is cancelled.
+func (e *Endpoint) CallContext(ctx context.Context, subPath, query, requestBody string) (*APIResponse, error) {
+	backend, err := e.Backend()
+	if err != nil {
+		return nil, err
+	}
+
+	key := cacheKey(e.Method, subPath+"?"+query, requestBody)
+	if cached, ok := e.cache.get(key); ok {
+		return cached, nil
+	}
+
+	apiResponse, err := backend.Call(ctx, subPath, query, requestBody)
+	if err != nil {
+		return apiResponse, err
+	}
+
+	err = e.ValidateResponse(apiResponse)
+	if err != nil {
+		return apiResponse, err
+	}
+
+	if e.Cache.TTL > 0 && apiResponse.Status >= 200 && apiResponse.Status < 300 {
+		e.cache.set(key, apiResponse, e.Cache.TTL)
+	}
+	return apiResponse, nil
+}
+
+// httpBackend calls the endpoint's URL over http(s).
+type httpBackend struct {
+	e *Endpoint
+}
+
+func (b *httpBackend) Call(ctx context.Context, subPath, query, requestBody string) (*APIResponse, error) {
+	e := b.e
 	u, err := e.GetUrl(subPath, query)
 	if err != nil {
 		return nil, fmt.Errorf("unable to build URL (%s): %v", e.URL, err)
 	}
+
+	raw, attempts, err := e.doWithRetry(ctx, e.Method, u, requestBody, nil, idempotent(e.Method))
+	if err != nil {
+		return nil, err
+	}
+
+	apiResponse := &APIResponse{Status: raw.status, Headers: raw.header, attempts: attempts}
+	apiResponse.Data, err = e.decode(raw)
+	if err != nil {
+		apiResponse.Data = map[string]interface{}{"error": "unable to unmarshal response body"}
+		return apiResponse, fmt.Errorf("unable to unmarshal response body: %v -- request '%s' -- raw body '%s'", err, u.String(), string(raw.body))
+	}
+	return apiResponse, nil
+}
+
+// rawResponse is an http response with the body read.
+type rawResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotent returns true for request methods that can safely be repeated.
+func idempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryable returns true if a call that returned response and err should be retried.
+func retryable(response *rawResponse, err error) bool {
+	return err != nil || response.status >= 500
+}
+
+// doWithRetry makes the request described by the endpoint according to the endpoint's RetryPolicy.  Requests that
+// aren't idempotent are only retried if the policy allows retrying all methods.  The last response is returned along
+// with the number of attempts made.
+func (e *Endpoint) doWithRetry(ctx context.Context, method string, u *url.URL, requestBody string, header http.Header, idempotent bool) (*rawResponse, int, error) {
+	attempts := e.Retry.Attempts
+	if attempts < 1 || (!idempotent && !e.Retry.AllMethods) {
+		attempts = 1
+	}
+	backoff := e.Retry.Backoff
+
+	var response *rawResponse
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		response, err = e.do(ctx, method, u, requestBody, header)
+		if !retryable(response, err) || attempt == attempts {
+			return response, attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return response, attempt, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if e.Retry.MaxBackoff > 0 && backoff > e.Retry.MaxBackoff {
+			backoff = e.Retry.MaxBackoff
+		}
+	}
+	return response, attempts, err
+}
+
+// redactURL returns u without userinfo or query, either of which may hold secrets rendered into the url.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	redacted.RawQuery = ""
+	redacted.ForceQuery = false
+	return redacted.String()
+}
+
+// RedactURL returns rawurl without userinfo or query.  It works on the string rather than a parsed url so
+// that unrendered endpoint urls containing template actions are reported as written.
+func RedactURL(rawurl string) string {
+	if i := strings.Index(rawurl, "?"); i >= 0 {
+		rawurl = rawurl[:i]
+	}
+
+	if scheme := strings.Index(rawurl, "://"); scheme >= 0 {
+		host := rawurl[scheme+3:]
+		if slash := strings.Index(host, "/"); slash >= 0 {
+			host = host[:slash]
+		}
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			rawurl = rawurl[:scheme+3] + rawurl[scheme+3+at+1:]
+		}
+	}
+	return rawurl
+}
+
+// do makes a single request to the endpoint returning the response with its body read.
+func (e *Endpoint) do(ctx context.Context, method string, u *url.URL, requestBody string, header http.Header) (*rawResponse, error) {
 	var body io.Reader
-	switch e.Method {
+	switch method {
 	case http.MethodGet:
 		body = nil
 	default:
 		body = bytes.NewBufferString(requestBody)
 	}
-	req, err := http.NewRequest(e.Method, u.String(), body)
+	req, err := http.NewRequest(method, u.String(), body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
+	for field, values := range header {
+		req.Header[field] = values
+	}
+	if span := trace.GetSpanFromContext(ctx); span != nil {
+		span.AddField("datasource.url", redactURL(u))
+		req.Header.Set(propagation.TracePropagationHTTPHeader, span.SerializeHeaders())
+	}
 
-	response, err := e.makeRequest(req)
+	response, err := e.makeRequest(req.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("unable to make http request: %v", err)
 	}
+	defer response.Body.Close()
 
-	apiResponse := &APIResponse{Status: response.StatusCode, Data: make(map[string]interface{})}
 	rawBodyData, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		apiResponse.Data.(map[string]interface{})["error"] = "unable to read api response body"
-		return apiResponse, fmt.Errorf("unable to read response body: %v", err)
-	}
-
-	err = json.Unmarshal(rawBodyData, &(apiResponse.Data))
-	if err != nil {
-		apiResponse.Data.(map[string]interface{})["error"] = "unable to unmarshal response body"
-		return apiResponse, fmt.Errorf("unable to unmarshal response body: %v -- request '%s' -- raw body '%s'", err, u.String(), string(rawBodyData))
+		return nil, fmt.Errorf("unable to read response body: %v", err)
 	}
-	return apiResponse, err
+	return &rawResponse{status: response.StatusCode, header: response.Header, body: rawBodyData}, nil
 }
 
 func (e *Endpoint) iamCredentials() *session.Session {
 	if e.iamSession == nil || e.iamSession.Config.Credentials.IsExpired() {
-		e.iamSession = session.New()
+		cfg := aws.NewConfig()
+		if e.AWS.Region != "" {
+			cfg = cfg.WithRegion(e.AWS.Region)
+		}
+		e.iamSession = session.New(cfg)
+		e.roleCredentials = nil
 	}
 
 	return e.iamSession
 }
 
+// iamSigner returns a signer using the credentials for the endpoint and the region requests should be signed for.
+func (e *Endpoint) iamSigner() (*iamsign.Signer, string, error) {
+	e.iamMu.Lock()
+	defer e.iamMu.Unlock()
+
+	sess := e.iamCredentials()
+	region := aws.StringValue(sess.Config.Region)
+	if region == "" {
+		return nil, "", fmt.Errorf("no aws region configured")
+	}
+
+	creds := sess.Config.Credentials
+	if e.AWS.RoleARN != "" {
+		if e.roleCredentials == nil {
+			e.roleCredentials = stscreds.NewCredentials(sess, e.AWS.RoleARN)
+		}
+		creds = e.roleCredentials
+	}
+	return iamsign.NewSigner(creds), region, nil
+}
+
 func (e *Endpoint) iamAuth(r *http.Request, signTime time.Time) error {
 	var body io.ReadSeeker
 	if r.Body != nil {
@@ -110,26 +356,47 @@ func (e *Endpoint) iamAuth(r *http.Request, signTime time.Time) error {
 		body = bytes.NewReader([]byte{})
 	}
 
-	sess := e.iamCredentials()
-	region := *sess.Config.Region
-	service := "execute-api"
-	signer := iamsign.NewSigner(sess.Config.Credentials)
-	_, err := signer.Sign(r, body, service, region, signTime)
+	signer, region, err := e.iamSigner()
+	if err != nil {
+		return err
+	}
+
+	service := e.AWS.Service
+	if service == "" {
+		service = DefaultAWSService
+	}
+	_, err = signer.Sign(r, body, service, region, signTime)
 	return err
 }
 
 func (e *Endpoint) addAuth(r *http.Request) error {
 	switch e.Auth {
+	case "":
+		return nil
 	case "iam":
 		return e.iamAuth(r, time.Now())
-	default:
+	case "bearer":
+		return e.bearerAuth(r)
+	case "basic":
+		return e.basicAuth(r)
+	case "mtls":
+		if e.TLS.Cert == "" || e.TLS.Key == "" {
+			return fmt.Errorf("mtls auth requires a client cert and key")
+		}
 		return nil
+	default:
+		return fmt.Errorf("unsupported auth mode: %s", e.Auth)
 	}
 }
 
 func (e *Endpoint) makeRequest(r *http.Request) (*http.Response, error) {
-	c := http.Client{}
-	err := e.addAuth(r)
+	transport, err := e.httpTransport()
+	if err != nil {
+		return nil, fmt.Errorf("unable to configure tls: %v", err)
+	}
+
+	c := http.Client{Transport: transport, Timeout: e.Timeout}
+	err = e.addAuth(r)
 	if err != nil {
 		return nil, fmt.Errorf("error modifying request to add authentication: %v", err)
 	}
@@ -141,10 +408,49 @@ type EndpointMap map[string]*Endpoint
 
 // Call the endpoint from the map with the provided arguments
 func (m EndpointMap) Call(endpoint, subPath, query, requestBody string) (*APIResponse, error) {
+	return m.CallContext(context.Background(), endpoint, subPath, query, requestBody)
+}
+
+// CallContext calls the endpoint from the map with the provided arguments.  If ctx contains a trace span the
+// call is recorded in a child span, and whether the response was served from the cache and the number of
+// attempts made are also recorded on the parent span.
+func (m EndpointMap) CallContext(ctx context.Context, endpoint, subPath, query, requestBody string) (*APIResponse, error) {
 	e, ok := m[endpoint]
 	if !ok {
 		return nil, fmt.Errorf("endpoint not found: %s", endpoint)
 	}
 
-	return e.Call(subPath, query, requestBody)
+	parent := trace.GetSpanFromContext(ctx)
+	var span *trace.Span
+	if parent != nil {
+		ctx, span = parent.CreateChild(ctx)
+		defer span.Send()
+		span.AddField("name", "datasource")
+		span.AddField("meta.type", "datasource")
+		span.AddField("datasource.name", endpoint)
+		span.AddField("datasource.type", e.Type)
+	}
+
+	response, err := e.CallContext(ctx, subPath, query, requestBody)
+	if schemaErr, ok := err.(*SchemaError); ok {
+		schemaErr.DataSource = endpoint
+	}
+	if span != nil {
+		if err != nil {
+			span.AddField("error", err.Error())
+		}
+		if response != nil {
+			span.AddField("datasource.status", response.Status)
+			span.AddField("datasource.cache_hit", response.Cached)
+			span.AddField("datasource.attempts", response.attempts)
+		}
+	}
+
+	if parent != nil && response != nil {
+		parent.AddField(fmt.Sprintf("datasource.%s.cache_hit", endpoint), response.Cached)
+		if !response.Cached {
+			parent.AddField(fmt.Sprintf("datasource.%s.attempts", endpoint), response.attempts)
+		}
+	}
+	return response, err
 }
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Secret is a value read from the environment or a file when a request is made, so that it doesn't
+// need to be stored in the distro repository.  Trailing whitespace is removed from values read from files.
+type Secret struct {
+	Env  string `mapstructure:"env"`
+	File string `mapstructure:"file"`
+}
+
+// IsSet returns true if a source is configured for the secret.
+func (s Secret) IsSet() bool {
+	return s.Env != "" || s.File != ""
+}
+
+// Value returns the current value of the secret.
+func (s Secret) Value() (string, error) {
+	switch {
+	case s.Env != "":
+		value, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", s.Env)
+		}
+		return value, nil
+	case s.File != "":
+		data, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("unable to read secret file: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n\t "), nil
+	default:
+		return "", fmt.Errorf("no env or file configured for secret")
+	}
+}
+
+// Credentials are used by the bearer and basic auth modes.
+type Credentials struct {
+	Token    Secret `mapstructure:"token"`
+	Username string `mapstructure:"username"`
+	Password Secret `mapstructure:"password"`
+}
+
+// TLSConfig configures the CA used to verify the server and the client certificate presented by the mtls
+// auth mode.  All values are paths to PEM encoded files.
+type TLSConfig struct {
+	CA   string `mapstructure:"ca"`
+	Cert string `mapstructure:"cert"`
+	Key  string `mapstructure:"key"`
+}
+
+// IsSet returns true if any TLS options are configured.
+func (c TLSConfig) IsSet() bool {
+	return c.CA != "" || c.Cert != "" || c.Key != ""
+}
+
+// Config builds a tls.Config from the configured files.
+func (c TLSConfig) Config() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if c.CA != "" {
+		caData, err := ioutil.ReadFile(c.CA)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ca: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in ca file %s", c.CA)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if c.Cert != "" || c.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func newTLSTransport(cfg *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       cfg,
+	}
+}
+
+// httpTransport returns the transport used to make requests.  A transport set with SetTransport takes
+// precedence, otherwise a transport using the configured TLS options is built on first use.
+func (e *Endpoint) httpTransport() (http.RoundTripper, error) {
+	if e.transport != nil || !e.TLS.IsSet() {
+		return e.transport, nil
+	}
+
+	e.tlsMu.Lock()
+	defer e.tlsMu.Unlock()
+	if e.tlsTransport == nil {
+		cfg, err := e.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+		e.tlsTransport = newTLSTransport(cfg)
+	}
+	return e.tlsTransport, nil
+}
+
+func (e *Endpoint) bearerAuth(r *http.Request) error {
+	token, err := e.Credentials.Token.Value()
+	if err != nil {
+		return fmt.Errorf("unable to get bearer token: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (e *Endpoint) basicAuth(r *http.Request) error {
+	if e.Credentials.Username == "" {
+		return fmt.Errorf("no username configured for basic auth")
+	}
+
+	password, err := e.Credentials.Password.Value()
+	if err != nil {
+		return fmt.Errorf("unable to get basic auth password: %v", err)
+	}
+	r.SetBasicAuth(e.Credentials.Username, password)
+	return nil
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestBearerAuthEnv(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	os.Setenv("TEST_API_TOKEN", "s3cr3t")
+	defer os.Unsetenv("TEST_API_TOKEN")
+
+	e := &Endpoint{URL: "https://api.local/v1/foo", Method: http.MethodGet, Auth: "bearer",
+		Credentials: Credentials{Token: Secret{Env: "TEST_API_TOKEN"}}}
+	gock.New("https://api.local/v1").
+		Get("/foo").
+		MatchHeader("Authorization", "^Bearer s3cr3t$").
+		Reply(200).
+		JSON(map[string]string{"foo": "bar"})
+
+	_, err := e.Call("", "", "")
+	if err != nil {
+		t.Errorf("API call failed: %v", err)
+	}
+}
+
+func TestBearerAuthFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pgcboot-api")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte("filetoken\n"), 0600)
+	if err != nil {
+		t.Fatalf("unable to write token file: %v", err)
+	}
+
+	e := &Endpoint{Auth: "bearer", Credentials: Credentials{Token: Secret{File: tokenFile}}}
+	r, _ := http.NewRequest(http.MethodGet, "https://api.local/v1/foo", nil)
+	err = e.addAuth(r)
+	if err != nil {
+		t.Fatalf("unable to add auth: %v", err)
+	}
+
+	if r.Header.Get("Authorization") != "Bearer filetoken" {
+		t.Errorf("wrong authorization header: %s", r.Header.Get("Authorization"))
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	os.Setenv("TEST_API_PASSWORD", "hunter2")
+	defer os.Unsetenv("TEST_API_PASSWORD")
+
+	e := &Endpoint{Auth: "basic", Credentials: Credentials{Username: "pgc", Password: Secret{Env: "TEST_API_PASSWORD"}}}
+	r, _ := http.NewRequest(http.MethodGet, "https://api.local/v1/foo", nil)
+	err := e.addAuth(r)
+	if err != nil {
+		t.Fatalf("unable to add auth: %v", err)
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok || username != "pgc" || password != "hunter2" {
+		t.Errorf("wrong basic auth credentials: %s %s", username, password)
+	}
+}
+
+func TestAuthErrors(t *testing.T) {
+	os.Unsetenv("TEST_API_MISSING")
+	cases := map[string]*Endpoint{
+		"unknown mode":    {Auth: "kerberos"},
+		"missing token":   {Auth: "bearer", Credentials: Credentials{Token: Secret{Env: "TEST_API_MISSING"}}},
+		"no token source": {Auth: "bearer"},
+		"no username":     {Auth: "basic"},
+		"mtls no cert":    {Auth: "mtls", TLS: TLSConfig{CA: "ca.pem"}},
+	}
+
+	for name, e := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "https://api.local/v1/foo", nil)
+		if err := e.addAuth(r); err == nil {
+			t.Errorf("%s: expected an error adding auth", name)
+		}
+	}
+}
+
+// writeTestCert creates a self signed certificate and key, returning the paths to the PEM files.
+func writeTestCert(t *testing.T, dir, name string, template *x509.Certificate) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %v", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatalf("unable to write certificate: %v", err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatalf("unable to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestMTLSAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pgcboot-api")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCert, clientKey := writeTestCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	})
+
+	clientPEM, err := ioutil.ReadFile(clientCert)
+	if err != nil {
+		t.Fatalf("unable to read client cert: %v", err)
+	}
+	clientPool := x509.NewCertPool()
+	clientPool.AppendCertsFromPEM(clientPEM)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"client": "` + r.TLS.PeerCertificates[0].Subject.CommonName + `"}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientPool}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatalf("unable to write ca: %v", err)
+	}
+
+	e := &Endpoint{URL: server.URL + "/foo", Method: http.MethodGet, Auth: "mtls",
+		TLS: TLSConfig{CA: caFile, Cert: clientCert, Key: clientKey}}
+	data, err := e.Call("", "", "")
+	if err != nil {
+		t.Fatalf("API call failed: %v", err)
+	}
+
+	if data.Data.(map[string]interface{})["client"] != "client" {
+		t.Errorf("server didn't see the client certificate: %v", data.Data)
+	}
+
+	noCert := &Endpoint{URL: server.URL + "/foo", Method: http.MethodGet, TLS: TLSConfig{CA: caFile}}
+	_, err = noCert.Call("", "", "")
+	if err == nil {
+		t.Errorf("expected call without a client certificate to fail")
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// CallSpec describes a single call to a datasource in an EndpointMap.
+type CallSpec struct {
+	DataSource string `mapstructure:"datasource"`
+	Path       string `mapstructure:"path"`
+	Query      string `mapstructure:"query"`
+	Body       string `mapstructure:"body"`
+}
+
+// CallResult is the outcome of a call made by CallAll.  Response is nil if the call failed with an error.
+type CallResult struct {
+	Response *APIResponse
+	Error    string
+
+	err error
+}
+
+// Err returns the error the call failed with, or nil.  Unlike Error it keeps the type of the error, such as a
+// *SchemaError.
+func (r *CallResult) Err() error {
+	return r.err
+}
+
+// CallAll makes each of the supplied calls concurrently and returns the results under the same keys.  A
+// failed call doesn't prevent the other calls from completing; its error is recorded in the result.
+func (m EndpointMap) CallAll(ctx context.Context, calls map[string]*CallSpec) map[string]*CallResult {
+	results := make(map[string]*CallResult, len(calls))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, call := range calls {
+		wg.Add(1)
+		go func(name string, call *CallSpec) {
+			defer wg.Done()
+			result := &CallResult{}
+			response, err := m.CallContext(ctx, call.DataSource, call.Path, call.Query, call.Body)
+			if err != nil {
+				result.Error = err.Error()
+				result.err = err
+			} else {
+				result.Response = response
+			}
+
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, call)
+	}
+	wg.Wait()
+	return results
+}
+
+// ParseCallSpec converts the arguments to a template function into a CallSpec.  value is either a list of
+// up to four strings (datasource, path, query, body) or a map with those keys.
+func ParseCallSpec(value interface{}) (*CallSpec, error) {
+	switch v := value.(type) {
+	case *CallSpec:
+		return v, nil
+	case []interface{}:
+		if len(v) < 1 || len(v) > 4 {
+			return nil, fmt.Errorf("call must have between 1 and 4 arguments, got %d", len(v))
+		}
+
+		args := make([]string, 4)
+		for i, arg := range v {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("call argument %d must be a string, got %T", i, arg)
+			}
+			args[i] = s
+		}
+		return &CallSpec{DataSource: args[0], Path: args[1], Query: args[2], Body: args[3]}, nil
+	case []string:
+		args := make([]interface{}, len(v))
+		for i, arg := range v {
+			args[i] = arg
+		}
+		return ParseCallSpec(args)
+	case map[string]interface{}:
+		spec := &CallSpec{}
+		fields := map[string]*string{"datasource": &spec.DataSource, "path": &spec.Path, "query": &spec.Query, "body": &spec.Body}
+		for key, arg := range v {
+			field, ok := fields[key]
+			if !ok {
+				return nil, fmt.Errorf("unknown call field: %s", key)
+			}
+
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("call field %s must be a string, got %T", key, arg)
+			}
+			*field = s
+		}
+		return spec, nil
+	default:
+		return nil, fmt.Errorf("unsupported call type: %T", value)
+	}
+}
+
+// Batch is intended to be used as a template function.  Each value in calls is parsed with ParseCallSpec and
+// the calls are made concurrently.  The results are returned under the same keys.
+func (m EndpointMap) Batch(calls map[string]interface{}) (map[string]*CallResult, error) {
+	return m.BatchContext(context.Background(), calls)
+}
+
+// BatchContext is Batch with the calls made using ctx.
+func (m EndpointMap) BatchContext(ctx context.Context, calls map[string]interface{}) (map[string]*CallResult, error) {
+	specs := make(map[string]*CallSpec, len(calls))
+	for name, call := range calls {
+		spec, err := ParseCallSpec(call)
+		if err != nil {
+			return nil, fmt.Errorf("invalid call %s: %v", name, err)
+		}
+		specs[name] = spec
+	}
+	return m.CallAll(ctx, specs), nil
+}
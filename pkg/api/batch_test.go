@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-test/deep"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestCallAll(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	gock.New("https://api.local/v1").
+		Get("/node/pgc-0001").
+		Reply(200).
+		JSON(map[string]string{"role": "compute"})
+	gock.New("https://api.local/v1").
+		Get("/network").
+		MatchParam("node", "pgc-0001").
+		Reply(200).
+		JSON(map[string]string{"ip": "10.0.0.1"})
+
+	m := EndpointMap{
+		"node":    &Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet},
+		"network": &Endpoint{URL: "https://api.local/v1/network", Method: http.MethodGet},
+	}
+	results := m.CallAll(context.Background(), map[string]*CallSpec{
+		"node":    {DataSource: "node", Path: "/pgc-0001"},
+		"network": {DataSource: "network", Query: "node=pgc-0001"},
+		"missing": {DataSource: "secrets"},
+	})
+
+	if len(results) != 3 {
+		t.Fatalf("wrong number of results returned: %d", len(results))
+	}
+
+	if results["node"].Error != "" || results["node"].Response.Data.(map[string]interface{})["role"] != "compute" {
+		t.Errorf("wrong result for node: %v %v", results["node"].Error, results["node"].Response)
+	}
+
+	if results["network"].Error != "" || results["network"].Response.Data.(map[string]interface{})["ip"] != "10.0.0.1" {
+		t.Errorf("wrong result for network: %v %v", results["network"].Error, results["network"].Response)
+	}
+
+	if results["missing"].Error == "" || results["missing"].Err() == nil || results["missing"].Response != nil {
+		t.Errorf("expected an error calling a missing datasource")
+	}
+}
+
+func TestParseCallSpec(t *testing.T) {
+	expected := &CallSpec{DataSource: "node", Path: "/pgc-0001", Query: "a=b"}
+	cases := map[string]interface{}{
+		"list":    []interface{}{"node", "/pgc-0001", "a=b"},
+		"strings": []string{"node", "/pgc-0001", "a=b"},
+		"map":     map[string]interface{}{"datasource": "node", "path": "/pgc-0001", "query": "a=b"},
+	}
+
+	for name, value := range cases {
+		spec, err := ParseCallSpec(value)
+		if err != nil {
+			t.Errorf("%s: unable to parse call: %v", name, err)
+			continue
+		}
+
+		if diff := deep.Equal(spec, expected); diff != nil {
+			t.Errorf("%s: %v", name, diff)
+		}
+	}
+
+	invalid := map[string]interface{}{
+		"empty list":    []interface{}{},
+		"too long":      []interface{}{"a", "b", "c", "d", "e"},
+		"non string":    []interface{}{"node", 1},
+		"unknown field": map[string]interface{}{"datasource": "node", "method": "POST"},
+		"wrong type":    "node",
+	}
+
+	for name, value := range invalid {
+		if _, err := ParseCallSpec(value); err == nil {
+			t.Errorf("%s: expected an error parsing call", name)
+		}
+	}
+}
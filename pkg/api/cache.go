@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	response *APIResponse
+	expires  time.Time
+}
+
+// responseCache stores successful APIResponses until they expire.  The zero value is ready to use.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+func cacheKey(method, u, body string) string {
+	return method + " " + u + "\n" + body
+}
+
+// get returns a copy of the cached response for key, marked as cached, if it hasn't expired.
+func (c *responseCache) get(key string) (*APIResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	response := copyResponse(entry.response)
+	response.Cached = true
+	return response, true
+}
+
+// set caches response under key for ttl.  Expired entries are removed.
+func (c *responseCache) set(key string, response *APIResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*cacheEntry)
+	}
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = &cacheEntry{response: copyResponse(response), expires: now.Add(ttl)}
+}
+
+// copyResponse returns a deep copy of response, so that callers modifying the data or headers of a response
+// don't change the cached copy.
+func copyResponse(response *APIResponse) *APIResponse {
+	c := *response
+	c.Data = copyValue(response.Data)
+	if response.Headers != nil {
+		c.Headers = make(http.Header, len(response.Headers))
+		for name, values := range response.Headers {
+			c.Headers[name] = append([]string(nil), values...)
+		}
+	}
+	return &c
+}
+
+// copyValue deep copies the maps and slices produced by decoding a response.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = copyValue(item)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, item := range v {
+			m[key] = copyValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = copyValue(item)
+		}
+		return l
+	case map[string]string:
+		m := make(map[string]string, len(v))
+		for key, item := range v {
+			m[key] = item
+		}
+		return m
+	case []string:
+		return append([]string(nil), v...)
+	case []byte:
+		return append([]byte(nil), v...)
+	default:
+		return v
+	}
+}
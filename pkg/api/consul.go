@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultConsulAddress is used by consul datasources when no other address is configured.
+const DefaultConsulAddress = "http://127.0.0.1:8500"
+
+// consulQueryParams are the query parameters that may be passed through to the KV api.
+var consulQueryParams = map[string]bool{"dc": true, "ns": true, "partition": true, "stale": true, "consistent": true}
+
+var consulDefaults struct {
+	sync.RWMutex
+	address string
+	token   string
+}
+
+// SetConsulDefaults sets the address and token used by consul datasources that don't configure their own.
+func SetConsulDefaults(address, token string) {
+	consulDefaults.Lock()
+	defer consulDefaults.Unlock()
+	consulDefaults.address, consulDefaults.token = address, token
+}
+
+// consulBackend reads values from the Consul KV store.  The key read is Key joined with subPath.  Only the
+// parameters in consulQueryParams may be given in query (eg. dc=east).  The value is decoded according to
+// Format.  The address is taken from URL, the defaults set with SetConsulDefaults or the CONSUL_HTTP_ADDR
+// environment variable, in that order.  Requests are authenticated with Credentials.Token, the default
+// token, or CONSUL_HTTP_TOKEN.
+type consulBackend struct {
+	e *Endpoint
+}
+
+func newConsulBackend(e *Endpoint) (Backend, error) {
+	return &consulBackend{e: e}, nil
+}
+
+func (b *consulBackend) baseURL() (*url.URL, error) {
+	if b.e.URL != "" {
+		return b.e.GetUrl("", "")
+	}
+
+	consulDefaults.RLock()
+	addr := consulDefaults.address
+	consulDefaults.RUnlock()
+	if addr == "" {
+		addr = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if addr == "" {
+		addr = DefaultConsulAddress
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return url.Parse(addr)
+}
+
+func (b *consulBackend) token() (string, error) {
+	if b.e.Credentials.Token.IsSet() {
+		return b.e.Credentials.Token.Value()
+	}
+
+	consulDefaults.RLock()
+	token := consulDefaults.token
+	consulDefaults.RUnlock()
+	if token != "" {
+		return token, nil
+	}
+	return os.Getenv("CONSUL_HTTP_TOKEN"), nil
+}
+
+// consulQuery parses query, returning an error if it contains parameters that aren't allowed.
+func consulQuery(query string) (url.Values, error) {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse query: %v", err)
+	}
+
+	for param := range q {
+		if !consulQueryParams[param] {
+			allowed := make([]string, 0, len(consulQueryParams))
+			for p := range consulQueryParams {
+				allowed = append(allowed, p)
+			}
+			sort.Strings(allowed)
+			return nil, fmt.Errorf("unsupported consul query parameter %s, expected one of %s", param, strings.Join(allowed, ", "))
+		}
+	}
+	return q, nil
+}
+
+func (b *consulBackend) Call(ctx context.Context, subPath, query, _ string) (*APIResponse, error) {
+	u, err := b.baseURL()
+	if err != nil {
+		return nil, fmt.Errorf("unable to build consul URL: %v", err)
+	}
+
+	key := strings.Trim(path.Join(b.e.Key, subPath), "/")
+	if key == "" {
+		return nil, fmt.Errorf("no consul key specified")
+	}
+	u.Path = path.Join(u.Path, "/v1/kv", key)
+
+	q, err := consulQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	q.Set("raw", "true")
+	u.RawQuery = q.Encode()
+
+	header := http.Header{}
+	token, err := b.token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get consul token: %v", err)
+	}
+	if token != "" {
+		header.Set("X-Consul-Token", token)
+	}
+
+	raw, attempts, err := b.e.doWithRetry(ctx, http.MethodGet, u, "", header, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw.status == http.StatusNotFound {
+		response := notFound(key)
+		response.Headers, response.attempts = raw.header, attempts
+		return response, nil
+	}
+
+	if raw.status != http.StatusOK {
+		return &APIResponse{Status: raw.status, Headers: raw.header, Data: map[string]interface{}{"error": string(raw.body)}, attempts: attempts}, nil
+	}
+
+	apiResponse := &APIResponse{Status: raw.status, Headers: raw.header, attempts: attempts}
+	apiResponse.Data, err = b.e.decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode consul key %s: %v", key, err)
+	}
+	return apiResponse, nil
+}
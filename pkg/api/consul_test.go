@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/go-test/deep"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestConsulBackend(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	os.Setenv("CONSUL_HTTP_TOKEN", "consul-token")
+	defer os.Unsetenv("CONSUL_HTTP_TOKEN")
+
+	gock.New("https://consul.local:8501").
+		Get("/v1/kv/pgcboot/nodes/node1").
+		MatchParam("raw", "true").
+		MatchParam("dc", "east").
+		MatchHeader("X-Consul-Token", "^consul-token$").
+		Reply(200).
+		BodyString(`{"role": "compute"}`)
+	gock.New("https://consul.local:8501").
+		Get("/v1/kv/pgcboot/nodes/node2").
+		Reply(404)
+
+	e := &Endpoint{Type: TypeConsul, URL: "https://consul.local:8501", Key: "pgcboot/nodes"}
+	response, err := e.Call("/node1", "dc=east", "")
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+
+	if diff := deep.Equal(response.Data, map[string]interface{}{"role": "compute"}); diff != nil {
+		t.Error(diff)
+	}
+
+	response, err = e.Call("/node2", "", "")
+	if err != nil {
+		t.Fatalf("call for missing key failed: %v", err)
+	}
+
+	if response.Status != http.StatusNotFound {
+		t.Errorf("wrong status for missing key: %d", response.Status)
+	}
+}
+
+func TestConsulBackendAddressFromEnv(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	os.Setenv("CONSUL_HTTP_ADDR", "consul.service:8500")
+	defer os.Unsetenv("CONSUL_HTTP_ADDR")
+
+	gock.New("http://consul.service:8500").
+		Get("/v1/kv/pgcboot/role").
+		Reply(200).
+		BodyString("compute")
+
+	e := &Endpoint{Type: TypeConsul, Key: "pgcboot", Format: FormatText}
+	response, err := e.Call("role", "", "")
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+
+	if response.Data != "compute" {
+		t.Errorf("wrong data returned: %v", response.Data)
+	}
+}
+
+func TestConsulBackendDefaults(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	SetConsulDefaults("consul.default:8500", "default-token")
+	defer SetConsulDefaults("", "")
+	os.Setenv("CONSUL_HTTP_ADDR", "consul.service:8500")
+	defer os.Unsetenv("CONSUL_HTTP_ADDR")
+
+	gock.New("http://consul.default:8500").
+		Get("/v1/kv/pgcboot/role").
+		MatchHeader("X-Consul-Token", "^default-token$").
+		Reply(200).
+		BodyString("compute")
+
+	e := &Endpoint{Type: TypeConsul, Key: "pgcboot", Format: FormatText}
+	response, err := e.Call("role", "", "")
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+
+	if response.Data != "compute" {
+		t.Errorf("wrong data returned: %v", response.Data)
+	}
+}
+
+func TestConsulBackendQueryParams(t *testing.T) {
+	e := &Endpoint{Type: TypeConsul, URL: "https://consul.local:8501", Key: "pgcboot"}
+	for _, query := range []string{"recurse=true", "keys", "token=other", "dc=east&raw=false"} {
+		if _, err := e.Call("role", query, ""); err == nil {
+			t.Errorf("expected an error for query %s", query)
+		}
+	}
+}
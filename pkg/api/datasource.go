@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Backend fetches data for an Endpoint.  subPath, query and requestBody are the arguments passed to Call;
+// each backend documents how it interprets them.
+type Backend interface {
+	Call(ctx context.Context, subPath, query, requestBody string) (*APIResponse, error)
+}
+
+// BackendFactory creates the Backend for an Endpoint, returning an error if the endpoint is misconfigured.
+type BackendFactory func(*Endpoint) (Backend, error)
+
+// Datasource types with built in backends.
+const (
+	// TypeHTTP calls URL over http(s).  This is the default.
+	TypeHTTP = "http"
+	// TypeFile reads a JSON or YAML file at Path, relative to the distro folder.
+	TypeFile = "file"
+	// TypeConsul reads values below Key from the Consul KV store at URL.
+	TypeConsul = "consul"
+	// TypeEtcd reads the value of Key, joined with the call's sub path, from the etcd v3 KV store at URL.
+	TypeEtcd = "etcd"
+)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendFactory{
+		TypeHTTP:   newHTTPBackend,
+		TypeFile:   newFileBackend,
+		TypeConsul: newConsulBackend,
+		TypeEtcd:   newEtcdBackend,
+	}
+)
+
+// RegisterBackend makes a Backend available to datasources with the supplied type, replacing any existing
+// backend with the same name.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = factory
+}
+
+// SetBasePath sets the folder that relative paths in the endpoint config are resolved against.
+func (e *Endpoint) SetBasePath(basePath string) {
+	e.basePath = basePath
+}
+
+// Backend returns the Backend for the endpoint's Type, creating it on first use.
+func (e *Endpoint) Backend() (Backend, error) {
+	e.backendMu.Lock()
+	defer e.backendMu.Unlock()
+	if e.backend != nil {
+		return e.backend, nil
+	}
+
+	t := strings.ToLower(e.Type)
+	if t == "" {
+		t = TypeHTTP
+	}
+
+	backendsMu.RLock()
+	factory, ok := backends[t]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown datasource type: %s", e.Type)
+	}
+
+	b, err := factory(e)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s datasource: %v", t, err)
+	}
+	e.backend = b
+	return b, nil
+}
+
+// notFound returns the response used by non-http backends when no value exists.
+func notFound(what string) *APIResponse {
+	return &APIResponse{Status: http.StatusNotFound, Data: map[string]interface{}{"error": fmt.Sprintf("not found: %s", what)}}
+}
+
+func newHTTPBackend(e *Endpoint) (Backend, error) {
+	return &httpBackend{e: e}, nil
+}
+
+// fileBackend serves data from a JSON or YAML file in the distro folder.  subPath selects a value within
+// the file, each path element indexing a map key or list position.  query and requestBody are ignored.
+type fileBackend struct {
+	e *Endpoint
+}
+
+func newFileBackend(e *Endpoint) (Backend, error) {
+	if e.Path == "" {
+		return nil, fmt.Errorf("no path configured")
+	}
+
+	clean := filepath.Clean(e.Path)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("path must be relative to and inside the distro folder: %s", e.Path)
+	}
+	return &fileBackend{e: e}, nil
+}
+
+func (b *fileBackend) format() string {
+	if b.e.Format != "" {
+		return b.e.Format
+	}
+
+	switch strings.ToLower(filepath.Ext(b.e.Path)) {
+	case ".yml", ".yaml":
+		return FormatYAML
+	default:
+		return FormatJSON
+	}
+}
+
+func (b *fileBackend) Call(_ context.Context, subPath, _, _ string) (*APIResponse, error) {
+	data, err := ioutil.ReadFile(filepath.Join(b.e.basePath, filepath.Clean(b.e.Path)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read datasource file: %v", err)
+	}
+
+	value, err := decodeFormat(b.format(), data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse datasource file %s: %v", b.e.Path, err)
+	}
+
+	value, ok := lookupPath(value, subPath)
+	if !ok {
+		return notFound(subPath), nil
+	}
+	return &APIResponse{Status: http.StatusOK, Data: value}, nil
+}
+
+// lookupPath walks value following the '/' separated elements of p.
+func lookupPath(value interface{}, p string) (interface{}, bool) {
+	for _, element := range strings.Split(p, "/") {
+		if element == "" {
+			continue
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			item, ok := v[element]
+			if !ok {
+				return nil, false
+			}
+			value = item
+		case []interface{}:
+			i, err := strconv.Atoi(element)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+const testNodesYaml = `nodes:
+  node1:
+    role: compute
+    macs:
+      - "00:11:22:33:44:55"
+`
+
+func TestFileBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pgcboot-api")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "data"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create data dir: %v", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "data", "nodes.yml"), []byte(testNodesYaml), 0644)
+	if err != nil {
+		t.Fatalf("unable to write data file: %v", err)
+	}
+
+	e := &Endpoint{Type: TypeFile, Path: "data/nodes.yml"}
+	e.SetBasePath(dir)
+
+	cases := []struct {
+		subPath  string
+		status   int
+		expected interface{}
+	}{
+		{"/nodes/node1/role", http.StatusOK, "compute"},
+		{"/nodes/node1/macs/0", http.StatusOK, "00:11:22:33:44:55"},
+		{"nodes/node1", http.StatusOK, map[string]interface{}{"role": "compute", "macs": []interface{}{"00:11:22:33:44:55"}}},
+		{"/nodes/node2", http.StatusNotFound, nil},
+		{"/nodes/node1/macs/1", http.StatusNotFound, nil},
+	}
+
+	for _, c := range cases {
+		response, err := e.Call(c.subPath, "", "")
+		if err != nil {
+			t.Errorf("%s: call failed: %v", c.subPath, err)
+			continue
+		}
+
+		if response.Status != c.status {
+			t.Errorf("%s: wrong status: expected %d, got %d", c.subPath, c.status, response.Status)
+		}
+
+		if c.expected == nil {
+			continue
+		}
+
+		if diff := deep.Equal(response.Data, c.expected); diff != nil {
+			t.Errorf("%s: %v", c.subPath, diff)
+		}
+	}
+}
+
+func TestFileBackendInvalidPath(t *testing.T) {
+	for _, p := range []string{"", "/etc/passwd", "../secrets.yml", "data/../../secrets.yml"} {
+		e := &Endpoint{Type: TypeFile, Path: p}
+		if _, err := e.Backend(); err == nil {
+			t.Errorf("expected an error creating a file backend for path '%s'", p)
+		}
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	e := &Endpoint{Type: "ldap"}
+	if _, err := e.Call("", "", ""); err == nil {
+		t.Errorf("expected an error calling a datasource with an unknown type")
+	}
+}
+
+type staticBackend struct {
+	data interface{}
+}
+
+func (b *staticBackend) Call(_ context.Context, _, _, _ string) (*APIResponse, error) {
+	return &APIResponse{Status: http.StatusOK, Data: b.data}, nil
+}
+
+func TestRegisterBackend(t *testing.T) {
+	RegisterBackend("static", func(e *Endpoint) (Backend, error) {
+		return &staticBackend{data: e.Key}, nil
+	})
+
+	m := EndpointMap{"static": &Endpoint{Type: "static", Key: "value"}}
+	response, err := m.Call("static", "", "", "")
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+
+	if response.Data != "value" {
+		t.Errorf("wrong data returned from registered backend: %v", response.Data)
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+)
+
+// DefaultEtcdAddress is used by etcd datasources when URL isn't set.
+const DefaultEtcdAddress = "http://127.0.0.1:2379"
+
+// etcdBackend reads values from etcd using the v3 grpc-gateway json api.  The key read is Key joined with
+// subPath, query and requestBody are ignored.  The value is decoded according to Format, as etcd doesn't
+// record a content type the auto format returns the value raw.  If
+// Credentials.Username is set the backend authenticates with the username and password, reusing the token
+// until etcd rejects it.
+type etcdBackend struct {
+	e *Endpoint
+
+	tokenMu sync.Mutex
+	token   string
+}
+
+type etcdKeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+func newEtcdBackend(e *Endpoint) (Backend, error) {
+	return &etcdBackend{e: e}, nil
+}
+
+func (b *etcdBackend) baseURL() (*url.URL, error) {
+	if b.e.URL != "" {
+		return b.e.GetUrl("", "")
+	}
+	return url.Parse(DefaultEtcdAddress)
+}
+
+// post sends a json request to the etcd api at apiPath and decodes the json response into result.
+func (b *etcdBackend) post(ctx context.Context, apiPath string, request interface{}, header http.Header, result interface{}) (*rawResponse, int, error) {
+	u, err := b.baseURL()
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to build etcd URL: %v", err)
+	}
+	u.Path = path.Join(u.Path, apiPath)
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	h := http.Header{"Content-Type": []string{"application/json"}}
+	for field, values := range header {
+		h[field] = values
+	}
+
+	// the v3 gateway only accepts POST, but range and authenticate requests don't modify anything
+	raw, attempts, err := b.e.doWithRetry(ctx, http.MethodPost, u, string(body), h, true)
+	if err != nil {
+		return nil, attempts, err
+	}
+
+	if raw.status != http.StatusOK {
+		return raw, attempts, nil
+	}
+
+	err = json.Unmarshal(raw.body, result)
+	if err != nil {
+		return nil, attempts, fmt.Errorf("unable to parse etcd response: %v", err)
+	}
+	return raw, attempts, nil
+}
+
+func (b *etcdBackend) authenticate(ctx context.Context) (string, error) {
+	password, err := b.e.Credentials.Password.Value()
+	if err != nil {
+		return "", fmt.Errorf("unable to get etcd password: %v", err)
+	}
+
+	var result struct {
+		Token string `json:"token"`
+	}
+	request := map[string]string{"name": b.e.Credentials.Username, "password": password}
+	raw, _, err := b.post(ctx, "/v3/auth/authenticate", request, nil, &result)
+	if err != nil {
+		return "", err
+	}
+
+	if raw.status != http.StatusOK {
+		return "", fmt.Errorf("etcd authentication failed: %d %s", raw.status, string(raw.body))
+	}
+	return result.Token, nil
+}
+
+// authToken returns the cached token, authenticating if there isn't one or refresh is set.
+func (b *etcdBackend) authToken(ctx context.Context, refresh bool) (string, error) {
+	b.tokenMu.Lock()
+	defer b.tokenMu.Unlock()
+
+	if b.token == "" || refresh {
+		token, err := b.authenticate(ctx)
+		if err != nil {
+			return "", err
+		}
+		b.token = token
+	}
+	return b.token, nil
+}
+
+// rangeKey reads key, authenticating first if credentials are configured.  An expired token is replaced and the
+// request repeated once.
+func (b *etcdBackend) rangeKey(ctx context.Context, key string, result *etcdRangeResponse) (*rawResponse, int, error) {
+	request := map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(key))}
+	if b.e.Credentials.Username == "" {
+		return b.post(ctx, "/v3/kv/range", request, nil, result)
+	}
+
+	for refresh := false; ; refresh = true {
+		token, err := b.authToken(ctx, refresh)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		header := http.Header{"Authorization": []string{token}}
+		raw, attempts, err := b.post(ctx, "/v3/kv/range", request, header, result)
+		if err != nil || raw.status != http.StatusUnauthorized || refresh {
+			return raw, attempts, err
+		}
+	}
+}
+
+func (b *etcdBackend) Call(ctx context.Context, subPath, _, _ string) (*APIResponse, error) {
+	key := path.Join(b.e.Key, subPath)
+	if key == "" || key == "." {
+		return nil, fmt.Errorf("no etcd key specified")
+	}
+
+	var result etcdRangeResponse
+	raw, attempts, err := b.rangeKey(ctx, key, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw.status != http.StatusOK {
+		return &APIResponse{Status: raw.status, Headers: raw.header, Data: map[string]interface{}{"error": string(raw.body)}, attempts: attempts}, nil
+	}
+
+	if len(result.Kvs) == 0 {
+		response := notFound(key)
+		response.Headers, response.attempts = raw.header, attempts
+		return response, nil
+	}
+
+	value, err := base64.StdEncoding.DecodeString(result.Kvs[0].Value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode etcd value for %s: %v", key, err)
+	}
+
+	// etcd doesn't record a content type, so values of auto format endpoints are returned raw
+	data, err := b.e.decode(&rawResponse{status: raw.status, header: http.Header{}, body: value})
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode etcd key %s: %v", key, err)
+	}
+	return &APIResponse{Status: raw.status, Headers: raw.header, Data: data, attempts: attempts}, nil
+}
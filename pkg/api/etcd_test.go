@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/go-test/deep"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestEtcdBackend(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	os.Setenv("TEST_ETCD_PASSWORD", "etcdpass")
+	defer os.Unsetenv("TEST_ETCD_PASSWORD")
+
+	key := base64.StdEncoding.EncodeToString([]byte("/pgcboot/nodes/node1"))
+	missingKey := base64.StdEncoding.EncodeToString([]byte("/pgcboot/nodes/node2"))
+	value := base64.StdEncoding.EncodeToString([]byte("role: compute\n"))
+
+	gock.New("http://etcd.local:2379").
+		Post("/v3/auth/authenticate").
+		JSON(map[string]string{"name": "pgcboot", "password": "etcdpass"}).
+		Reply(200).
+		JSON(map[string]string{"token": "etcd-token"})
+	gock.New("http://etcd.local:2379").
+		Post("/v3/kv/range").
+		MatchHeader("Authorization", "^etcd-token$").
+		JSON(map[string]string{"key": key}).
+		Reply(200).
+		JSON(map[string]interface{}{"kvs": []map[string]string{{"key": key, "value": value}}, "count": "1"})
+	gock.New("http://etcd.local:2379").
+		Post("/v3/kv/range").
+		JSON(map[string]string{"key": missingKey}).
+		Reply(200).
+		JSON(map[string]interface{}{})
+
+	e := &Endpoint{Type: TypeEtcd, URL: "http://etcd.local:2379", Key: "/pgcboot/nodes", Format: FormatYAML,
+		Credentials: Credentials{Username: "pgcboot", Password: Secret{Env: "TEST_ETCD_PASSWORD"}}}
+	response, err := e.Call("node1", "", "")
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+
+	if diff := deep.Equal(response.Data, map[string]interface{}{"role": "compute"}); diff != nil {
+		t.Error(diff)
+	}
+
+	response, err = e.Call("node2", "", "")
+	if err != nil {
+		t.Fatalf("call for missing key failed: %v", err)
+	}
+
+	if response.Status != http.StatusNotFound {
+		t.Errorf("wrong status for missing key: %d", response.Status)
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("not all expected requests were made")
+	}
+}
+
+func TestEtcdBackendTokenExpired(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	os.Setenv("TEST_ETCD_PASSWORD", "etcdpass")
+	defer os.Unsetenv("TEST_ETCD_PASSWORD")
+
+	value := base64.StdEncoding.EncodeToString([]byte("compute"))
+
+	gock.New("http://etcd.local:2379").
+		Post("/v3/auth/authenticate").
+		Reply(200).
+		JSON(map[string]string{"token": "old-token"})
+	gock.New("http://etcd.local:2379").
+		Post("/v3/kv/range").
+		MatchHeader("Authorization", "^old-token$").
+		Reply(200).
+		JSON(map[string]interface{}{"kvs": []map[string]string{{"value": value}}})
+	gock.New("http://etcd.local:2379").
+		Post("/v3/kv/range").
+		MatchHeader("Authorization", "^old-token$").
+		Reply(401).
+		JSON(map[string]interface{}{"error": "etcdserver: invalid auth token", "code": 16})
+	gock.New("http://etcd.local:2379").
+		Post("/v3/auth/authenticate").
+		Reply(200).
+		JSON(map[string]string{"token": "new-token"})
+	gock.New("http://etcd.local:2379").
+		Post("/v3/kv/range").
+		MatchHeader("Authorization", "^new-token$").
+		Reply(200).
+		JSON(map[string]interface{}{"kvs": []map[string]string{{"value": value}}})
+
+	e := &Endpoint{Type: TypeEtcd, URL: "http://etcd.local:2379", Key: "/pgcboot/role", Format: FormatText,
+		Credentials: Credentials{Username: "pgcboot", Password: Secret{Env: "TEST_ETCD_PASSWORD"}}}
+	for i := 0; i < 2; i++ {
+		response, err := e.Call("", "", "")
+		if err != nil {
+			t.Fatalf("call failed: %v", err)
+		}
+
+		if response.Status != http.StatusOK || response.Data != "compute" {
+			t.Errorf("wrong response: %d %v", response.Status, response.Data)
+		}
+	}
+
+	if !gock.IsDone() {
+		t.Errorf("expired token wasn't replaced")
+	}
+}
+
+func TestEtcdBackendAutoFormat(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	key := base64.StdEncoding.EncodeToString([]byte("/pgcboot/nodes/node1"))
+	value := base64.StdEncoding.EncodeToString([]byte("role: compute\n"))
+
+	gock.New("http://etcd.local:2379").
+		Post("/v3/kv/range").
+		JSON(map[string]string{"key": key}).
+		Reply(200).
+		JSON(map[string]interface{}{"kvs": []map[string]string{{"key": key, "value": value}}, "count": "1"})
+
+	e := &Endpoint{Type: TypeEtcd, URL: "http://etcd.local:2379", Key: "/pgcboot/nodes", Format: FormatAuto}
+	response, err := e.Call("node1", "", "")
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+
+	if diff := deep.Equal(response.Data, []byte("role: compute\n")); diff != nil {
+		t.Error(diff)
+	}
+}
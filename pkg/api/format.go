@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Response formats understood by an Endpoint.
+const (
+	// FormatJSON decodes the body as JSON.  This is the default.
+	FormatJSON = "json"
+	// FormatYAML decodes the body as YAML.  Maps are converted to map[string]interface{} so the data can be
+	// used in the same way as JSON data.
+	FormatYAML = "yaml"
+	// FormatText returns the body as a string.
+	FormatText = "text"
+	// FormatRaw returns the body as a []byte.
+	FormatRaw = "raw"
+	// FormatAuto selects one of the other formats based on the Content-Type of the response.
+	FormatAuto = "auto"
+)
+
+// formatForContentType returns the format used to decode a body with the supplied Content-Type.
+func formatForContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return FormatRaw
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return FormatJSON
+	case mediaType == "application/yaml" || mediaType == "application/x-yaml" || mediaType == "text/yaml" ||
+		mediaType == "text/x-yaml" || strings.HasSuffix(mediaType, "+yaml"):
+		return FormatYAML
+	case strings.HasPrefix(mediaType, "text/"):
+		return FormatText
+	default:
+		return FormatRaw
+	}
+}
+
+// NormalizeYAML converts the map[interface{}]interface{} values produced by the yaml parser into
+// map[string]interface{} so they can be used in the same way as JSON data.  Maps and slices are copied rather
+// than changed in place.
+func NormalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprintf("%v", key)] = NormalizeYAML(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = NormalizeYAML(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = NormalizeYAML(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// decode converts the body of the response to a value according to the endpoint's Format.
+func (e *Endpoint) decode(response *rawResponse) (interface{}, error) {
+	format := e.Format
+	if strings.ToLower(format) == FormatAuto {
+		format = formatForContentType(response.header.Get("Content-Type"))
+	}
+	return decodeFormat(format, response.body)
+}
+
+// decodeFormat converts body to a value according to format.  An empty format is treated as JSON.
+func decodeFormat(format string, body []byte) (interface{}, error) {
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		var data interface{} = make(map[string]interface{})
+		err := json.Unmarshal(body, &data)
+		return data, err
+	case FormatYAML:
+		var data interface{}
+		err := yaml.Unmarshal(body, &data)
+		return NormalizeYAML(data), err
+	case FormatText:
+		return string(body), nil
+	case FormatRaw:
+		return body, nil
+	default:
+		return nil, fmt.Errorf("unsupported response format: %s", format)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-test/deep"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestResponseFormats(t *testing.T) {
+	cases := []struct {
+		name        string
+		format      string
+		contentType string
+		body        string
+		expected    interface{}
+	}{
+		{"default json", "", "application/json", `{"foo": "bar"}`, map[string]interface{}{"foo": "bar"}},
+		{"yaml", FormatYAML, "text/plain", "foo:\n  bar: [1, 2]\n", map[string]interface{}{"foo": map[string]interface{}{"bar": []interface{}{1, 2}}}},
+		{"text", FormatText, "application/json", `{"foo": "bar"}`, `{"foo": "bar"}`},
+		{"raw", FormatRaw, "text/plain", "binary\x00data", []byte("binary\x00data")},
+		{"auto json", FormatAuto, "application/vnd.api+json; charset=utf-8", `["a"]`, []interface{}{"a"}},
+		{"auto yaml", FormatAuto, "application/x-yaml", "- a\n", []interface{}{"a"}},
+		{"auto text", FormatAuto, "text/plain; charset=utf-8", "hello", "hello"},
+		{"auto raw", FormatAuto, "application/octet-stream", "hello", []byte("hello")},
+	}
+
+	for _, c := range cases {
+		gock.New("https://api.local/v1").
+			Get("/foo").
+			Reply(200).
+			SetHeader("Content-Type", c.contentType).
+			BodyString(c.body)
+
+		e := &Endpoint{URL: "https://api.local/v1/foo", Method: http.MethodGet, Format: c.format}
+		response, err := e.Call("", "", "")
+		if err != nil {
+			t.Errorf("%s: API call failed: %v", c.name, err)
+			continue
+		}
+
+		if diff := deep.Equal(response.Data, c.expected); diff != nil {
+			t.Errorf("%s: %v", c.name, diff)
+		}
+
+		if response.Headers.Get("Content-Type") != c.contentType {
+			t.Errorf("%s: wrong Content-Type header returned: %s", c.name, response.Headers.Get("Content-Type"))
+		}
+	}
+	gock.Off()
+}
+
+func TestResponseFormatErrors(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	gock.New("https://api.local/v1").
+		Get("/foo").
+		Times(2).
+		Reply(200).
+		BodyString("not: [json")
+
+	for _, format := range []string{FormatJSON, "xml"} {
+		e := &Endpoint{URL: "https://api.local/v1/foo", Method: http.MethodGet, Format: format}
+		_, err := e.Call("", "", "")
+		if err == nil {
+			t.Errorf("expected an error decoding the response as %s", format)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Schema is a compiled JSON Schema.  The subset of the specification needed to describe datasource responses is
+// supported: type, enum, properties, required, additionalProperties, items, minItems, maxItems, minLength,
+// maxLength, pattern, minimum and maximum.  Annotations such as title and description are ignored, any other
+// keyword is rejected when the schema is parsed so that it can't silently accept every response.
+type Schema struct {
+	types                []string
+	enum                 []interface{}
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	noAdditional         bool
+	items                *Schema
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	minimum, maximum     *float64
+	pattern              *regexp.Regexp
+}
+
+// SchemaError describes the first part of a datasource response that doesn't match the datasource's schema.
+// Field is the dotted path to the failing value, empty for the root of the response.
+type SchemaError struct {
+	DataSource string
+	Field      string
+	Message    string
+}
+
+func (e *SchemaError) Error() string {
+	field := e.Field
+	if field == "" {
+		field = "(root)"
+	}
+	if e.DataSource == "" {
+		return fmt.Sprintf("response failed schema validation at %s: %s", field, e.Message)
+	}
+	return fmt.Sprintf("response from datasource %s failed schema validation at %s: %s", e.DataSource, field, e.Message)
+}
+
+// ResponseStatus returns the status to report to clients whose request failed because of the invalid response.
+func (e *SchemaError) ResponseStatus() int {
+	return http.StatusBadGateway
+}
+
+// ResponseBody returns the JSON body to report to clients whose request failed because of the invalid response.
+func (e *SchemaError) ResponseBody() interface{} {
+	return map[string]string{
+		"msg":        "Invalid response from datasource",
+		"datasource": e.DataSource,
+		"field":      e.Field,
+		"error":      e.Message,
+	}
+}
+
+func schemaInt(value interface{}, keyword string) (*int, error) {
+	f, ok := toFloat(value)
+	if !ok || f != math.Trunc(f) || f < 0 {
+		return nil, fmt.Errorf("%s must be a non-negative integer", keyword)
+	}
+	i := int(f)
+	return &i, nil
+}
+
+func schemaFloat(value interface{}, keyword string) (*float64, error) {
+	f, ok := toFloat(value)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a number", keyword)
+	}
+	return &f, nil
+}
+
+// schemaAnnotations are the keywords that don't affect validation.
+var schemaAnnotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"id":          true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"default":     true,
+	"examples":    true,
+	"readOnly":    true,
+	"writeOnly":   true,
+}
+
+// ParseSchema compiles a JSON Schema that has been decoded from JSON or YAML.
+func ParseSchema(definition interface{}) (*Schema, error) {
+	def, ok := NormalizeYAML(definition).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema must be an object, got %T", definition)
+	}
+
+	s := &Schema{}
+	var err error
+	for keyword, value := range def {
+		switch keyword {
+		case "type":
+			switch t := value.(type) {
+			case string:
+				s.types = []string{t}
+			case []interface{}:
+				for _, item := range t {
+					name, ok := item.(string)
+					if !ok {
+						return nil, fmt.Errorf("type must be a string or list of strings")
+					}
+					s.types = append(s.types, name)
+				}
+			default:
+				return nil, fmt.Errorf("type must be a string or list of strings")
+			}
+		case "enum":
+			enum, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("enum must be a list")
+			}
+			s.enum = enum
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("properties must be an object")
+			}
+			s.properties = make(map[string]*Schema, len(props))
+			for name, prop := range props {
+				if s.properties[name], err = ParseSchema(prop); err != nil {
+					return nil, fmt.Errorf("property %s: %v", name, err)
+				}
+			}
+		case "required":
+			required, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("required must be a list of strings")
+			}
+			for _, item := range required {
+				name, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("required must be a list of strings")
+				}
+				s.required = append(s.required, name)
+			}
+		case "additionalProperties":
+			if allowed, ok := value.(bool); ok {
+				s.noAdditional = !allowed
+			} else if s.additionalProperties, err = ParseSchema(value); err != nil {
+				return nil, fmt.Errorf("additionalProperties: %v", err)
+			}
+		case "items":
+			if s.items, err = ParseSchema(value); err != nil {
+				return nil, fmt.Errorf("items: %v", err)
+			}
+		case "minItems":
+			s.minItems, err = schemaInt(value, keyword)
+		case "maxItems":
+			s.maxItems, err = schemaInt(value, keyword)
+		case "minLength":
+			s.minLength, err = schemaInt(value, keyword)
+		case "maxLength":
+			s.maxLength, err = schemaInt(value, keyword)
+		case "minimum":
+			s.minimum, err = schemaFloat(value, keyword)
+		case "maximum":
+			s.maximum, err = schemaFloat(value, keyword)
+		case "pattern":
+			p, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("pattern must be a string")
+			}
+			s.pattern, err = regexp.Compile(p)
+		default:
+			if !schemaAnnotations[keyword] {
+				return nil, fmt.Errorf("unsupported schema keyword: %s", keyword)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// LoadSchema reads and compiles a JSON or YAML schema file.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return CompileSchema(data)
+}
+
+// CompileSchema compiles a JSON or YAML schema document.
+func CompileSchema(data []byte) (*Schema, error) {
+	var definition interface{}
+	err := yaml.Unmarshal(data, &definition)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse schema: %v", err)
+	}
+	return ParseSchema(definition)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+
+	if f, ok := toFloat(value); ok {
+		if f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func (s *Schema) matchesType(value interface{}) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+
+	actual := typeOf(value)
+	for _, t := range s.types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func joinField(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "." + child
+}
+
+// Validate checks value against the schema, returning a *SchemaError describing the first violation found.
+func (s *Schema) Validate(value interface{}) error {
+	return s.validate("", NormalizeYAML(value))
+}
+
+func (s *Schema) validate(field string, value interface{}) error {
+	fail := func(format string, args ...interface{}) error {
+		return &SchemaError{Field: field, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if !s.matchesType(value) {
+		return fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(value))
+	}
+
+	if len(s.enum) > 0 {
+		found := false
+		for _, allowed := range s.enum {
+			if reflect.DeepEqual(NormalizeYAML(allowed), value) {
+				found = true
+				break
+			}
+			if a, ok := toFloat(allowed); ok {
+				if v, ok := toFloat(value); ok && a == v {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return fail("value %v is not one of %v", value, s.enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return &SchemaError{Field: joinField(field, name), Message: "required field is missing"}
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			prop, ok := s.properties[name]
+			switch {
+			case ok:
+			case s.additionalProperties != nil:
+				prop = s.additionalProperties
+			case s.noAdditional:
+				return &SchemaError{Field: joinField(field, name), Message: "additional field not allowed"}
+			default:
+				continue
+			}
+
+			if err := prop.validate(joinField(field, name), v[name]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return fail("expected at least %d items, got %d", *s.minItems, len(v))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return fail("expected at most %d items, got %d", *s.maxItems, len(v))
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(joinField(field, strconv.Itoa(i)), item); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.minLength != nil && length < *s.minLength {
+			return fail("expected at least %d characters, got %d", *s.minLength, length)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return fail("expected at most %d characters, got %d", *s.maxLength, length)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fail("value %q doesn't match pattern %s", v, s.pattern)
+		}
+	default:
+		if f, ok := toFloat(v); ok {
+			if s.minimum != nil && f < *s.minimum {
+				return fail("value %v is less than the minimum %v", v, *s.minimum)
+			}
+			if s.maximum != nil && f > *s.maximum {
+				return fail("value %v is greater than the maximum %v", v, *s.maximum)
+			}
+		}
+	}
+	return nil
+}
+
+// schema returns the compiled schema for the endpoint, or nil if none is configured.  SchemaFile is resolved
+// relative to the endpoint's base path.
+func (e *Endpoint) schema() (*Schema, error) {
+	e.schemaMu.Lock()
+	defer e.schemaMu.Unlock()
+	if e.compiledSchema != nil || (e.Schema == "" && e.SchemaFile == "") {
+		return e.compiledSchema, nil
+	}
+
+	var err error
+	switch {
+	case e.Schema != "" && e.SchemaFile != "":
+		return nil, fmt.Errorf("only one of schema and schema_file may be set")
+	case e.Schema != "":
+		e.compiledSchema, err = CompileSchema([]byte(e.Schema))
+	default:
+		p := e.SchemaFile
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(e.basePath, p)
+		}
+		e.compiledSchema, err = LoadSchema(p)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	return e.compiledSchema, nil
+}
+
+// ValidateResponse checks the data in a successful response against the endpoint's schema, if any.  Responses
+// with a non-2xx status aren't validated.
+func (e *Endpoint) ValidateResponse(response *APIResponse) error {
+	if response == nil || response.Status < 200 || response.Status >= 300 {
+		return nil
+	}
+
+	s, err := e.schema()
+	if err != nil || s == nil {
+		return err
+	}
+	return s.Validate(response.Data)
+}
+
+// ValidateBody decodes body according to the endpoint's Format and checks it against the endpoint's schema.
+func (e *Endpoint) ValidateBody(body []byte, contentType string) error {
+	data, err := e.decode(&rawResponse{status: http.StatusOK, header: http.Header{"Content-Type": []string{contentType}}, body: body})
+	if err != nil {
+		return fmt.Errorf("unable to decode body: %v", err)
+	}
+	return e.ValidateResponse(&APIResponse{Status: http.StatusOK, Data: data})
+}
+
+// Validate checks that the endpoint's type and schema are valid.
+func (e *Endpoint) Validate() error {
+	if _, err := e.Backend(); err != nil {
+		return err
+	}
+	_, err := e.schema()
+	return err
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+const testNodeSchema = `type: object
+required: [InventoryID, Role, Networks]
+properties:
+  InventoryID:
+    type: string
+    pattern: "^pgc-[0-9]{4}$"
+  Role:
+    enum: [worker, storage, head]
+  Rack:
+    type: integer
+    minimum: 1
+    maximum: 40
+  Networks:
+    type: array
+    minItems: 1
+    items:
+      type: object
+      required: [IP]
+      additionalProperties: false
+      properties:
+        IP:
+          type: string
+          minLength: 7
+`
+
+func testSchema(t *testing.T) *Schema {
+	s, err := CompileSchema([]byte(testNodeSchema))
+	if err != nil {
+		t.Fatalf("unable to compile schema: %v", err)
+	}
+	return s
+}
+
+func TestSchemaValidate(t *testing.T) {
+	s := testSchema(t)
+	valid := map[string]interface{}{
+		"InventoryID": "pgc-0030",
+		"Role":        "worker",
+		"Rack":        float64(12),
+		"Networks":    []interface{}{map[string]interface{}{"IP": "10.0.0.30"}},
+	}
+	if err := s.Validate(valid); err != nil {
+		t.Errorf("valid value rejected: %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		key   string
+		value interface{}
+		field string
+	}{
+		{"missing field", "Role", nil, "Role"},
+		{"wrong type", "InventoryID", float64(30), "InventoryID"},
+		{"pattern", "InventoryID", "node-30", "InventoryID"},
+		{"enum", "Role", "login", "Role"},
+		{"minimum", "Rack", float64(0), "Rack"},
+		{"integer", "Rack", 1.5, "Rack"},
+		{"min items", "Networks", []interface{}{}, "Networks"},
+		{"nested", "Networks", []interface{}{map[string]interface{}{"IP": 10}}, "Networks.0.IP"},
+		{"additional", "Networks", []interface{}{map[string]interface{}{"IP": "10.0.0.30", "MAC": "x"}}, "Networks.0.MAC"},
+	}
+
+	for _, c := range cases {
+		value := make(map[string]interface{})
+		for k, v := range valid {
+			value[k] = v
+		}
+		if c.value == nil {
+			delete(value, c.key)
+		} else {
+			value[c.key] = c.value
+		}
+
+		err := s.Validate(value)
+		schemaErr, ok := err.(*SchemaError)
+		if !ok {
+			t.Errorf("%s: expected a schema error, got %v", c.name, err)
+			continue
+		}
+
+		if schemaErr.Field != c.field {
+			t.Errorf("%s: wrong field reported: expected %s, got %s", c.name, c.field, schemaErr.Field)
+		}
+	}
+}
+
+func TestParseSchemaInvalid(t *testing.T) {
+	cases := map[string]interface{}{
+		"not an object":  "string",
+		"bad type":       map[string]interface{}{"type": 1},
+		"bad pattern":    map[string]interface{}{"pattern": "["},
+		"bad minimum":    map[string]interface{}{"minimum": "one"},
+		"bad properties": map[string]interface{}{"properties": map[string]interface{}{"a": "b"}},
+		"ref":            map[string]interface{}{"$ref": "#/definitions/node"},
+		"oneOf":          map[string]interface{}{"oneOf": []interface{}{map[string]interface{}{"type": "string"}}},
+		"nested format":  map[string]interface{}{"properties": map[string]interface{}{"ip": map[string]interface{}{"type": "string", "format": "ipv4"}}},
+	}
+
+	for name, definition := range cases {
+		if _, err := ParseSchema(definition); err == nil {
+			t.Errorf("%s: expected an error compiling schema", name)
+		}
+	}
+}
+
+func TestParseSchemaAnnotations(t *testing.T) {
+	s, err := CompileSchema([]byte("$schema: http://json-schema.org/draft-07/schema#\ntitle: node\ndescription: A node\ntype: object\n"))
+	if err != nil {
+		t.Fatalf("Unable to compile schema with annotations: %v", err)
+	}
+
+	if err := s.Validate("node"); err == nil {
+		t.Errorf("Expected a schema error for the wrong type")
+	}
+}
+
+func TestCallSchemaValidation(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	cfg := viper.New()
+	cfg.SetConfigType("yaml")
+	err := cfg.ReadConfig(bytes.NewBufferString(`node:
+  url: https://api.local/v1/node
+  method: GET
+  schema: |
+    type: object
+    required: [Role]
+    properties:
+      Role:
+        type: string
+`))
+	if err != nil {
+		t.Fatalf("unable to read config: %v", err)
+	}
+
+	m := EndpointMap{}
+	if err := cfg.Unmarshal(&m); err != nil {
+		t.Fatalf("unable to unmarshal endpoints: %v", err)
+	}
+
+	if err := m["node"].Validate(); err != nil {
+		t.Fatalf("valid endpoint rejected: %v", err)
+	}
+
+	gock.New("https://api.local/v1").
+		Get("/node/good").
+		Reply(200).
+		JSON(map[string]string{"Role": "worker"})
+	gock.New("https://api.local/v1").
+		Get("/node/bad").
+		Reply(200).
+		JSON(map[string]int{"Role": 1})
+	gock.New("https://api.local/v1").
+		Get("/node/missing").
+		Reply(404).
+		JSON(map[string]string{"error": "not found"})
+
+	if _, err := m.Call("node", "/good", "", ""); err != nil {
+		t.Errorf("valid response rejected: %v", err)
+	}
+
+	if _, err := m.Call("node", "/missing", "", ""); err != nil {
+		t.Errorf("error responses shouldn't be validated: %v", err)
+	}
+
+	_, err = m.Call("node", "/bad", "", "")
+	schemaErr, ok := err.(*SchemaError)
+	if !ok {
+		t.Fatalf("expected a schema error, got %v", err)
+	}
+
+	if schemaErr.DataSource != "node" || schemaErr.Field != "Role" {
+		t.Errorf("wrong schema error returned: %v", schemaErr)
+	}
+
+	if schemaErr.ResponseStatus() != http.StatusBadGateway {
+		t.Errorf("wrong response status for schema error: %d", schemaErr.ResponseStatus())
+	}
+}
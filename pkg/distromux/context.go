@@ -4,9 +4,11 @@ import (
 	"context"
 )
 
-type contextKey struct{}
+type contextKey struct {
+	name string
+}
 
-var distroVarsContextKey = &contextKey{}
+var distroVarsContextKey = &contextKey{"distro vars"}
 
 func NewDistroVarsContext(parentCtx context.Context, vars DistroVars) context.Context {
 	return context.WithValue(parentCtx, distroVarsContextKey, &vars)
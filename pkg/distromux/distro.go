@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"path"
 	"path/filepath"
 
@@ -48,12 +49,16 @@ type DistroConfig struct {
 	DataSources api.EndpointMap `mapstructure:"datasources"`
 	Test        DistroTestSuite `mapstructure:"test"`
 	DistroVars  DistroVars      `mapstructure:"vars"`
+
+	// TrustedProxies lists the addresses or CIDR ranges of proxies allowed to set X-Forwarded-For.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
 type EndpointConfig struct {
 	Template map[string]*TemplateEndpoint `mapstructure:"template"`
 	Static   map[string]*StaticEndpoint   `mapstructure:"static"`
 	Proxy    map[string]*ProxyEndpoint    `mapstructure:"proxy"`
+	IPXE     map[string]*IPXEEndpoint     `mapstructure:"ipxe"`
 }
 
 // DistroMux configures a gorilla/mux Router that will serve the contents of a
@@ -82,6 +87,11 @@ func NewDistroMux(srcpath string, router *mux.Router) (*DistroMux, error) {
 	return &d, nil
 }
 
+// Config returns the parsed configuration for this DistroMux.
+func (d *DistroMux) Config() *DistroConfig {
+	return d.cfg
+}
+
 // config parses and returns the config for this DistroMux
 func (d *DistroMux) config() (*DistroConfig, error) {
 	cfg := viper.New()
@@ -98,8 +108,20 @@ func (d *DistroMux) config() (*DistroConfig, error) {
 	return &config, err
 }
 
+// addEndpoint adds a route for endpoint.  Endpoints embedding a RouteConfig may override path and add other
+// matchers, otherwise every request with a path starting with path is matched.
 func (d *DistroMux) addEndpoint(path string, endpoint Endpoint, dataSources api.EndpointMap) error {
-	route := d.Router.PathPrefix(path)
+	route := d.Router.NewRoute()
+	if routed, ok := endpoint.(routedEndpoint); ok {
+		var err error
+		route, err = routed.routeConfig().apply(route, path)
+		if err != nil {
+			return err
+		}
+	} else {
+		route = route.PathPrefix(path)
+	}
+
 	tmpl, err := route.GetPathTemplate()
 	if err != nil {
 		return err
@@ -115,6 +137,7 @@ func (d *DistroMux) addEndpoint(path string, endpoint Endpoint, dataSources api.
 			parentSpan := trace.GetSpanFromContext(r.Context())
 			if parentSpan != nil {
 				parentSpan.AddField("name", path)
+				parentSpan.AddField("route", tmpl)
 				parentSpan.AddField("base_path", d.basePath)
 			}
 			next.ServeHTTP(wr, r)
@@ -136,9 +159,22 @@ func (d *DistroMux) load() error {
 
 	d.Router.Use(DistroVarsMiddleware(d.Router, d.cfg.DistroVars))
 
+	for name, ds := range config.DataSources {
+		ds.SetBasePath(d.basePath)
+		if err = ds.Validate(); err != nil {
+			return fmt.Errorf("invalid datasource %s: %v", name, err)
+		}
+	}
+
+	trustedProxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
 	// add each endpoint found in the config to the mux
 	for p, endpoint := range config.Endpoints.Template {
 		cleanPath := path.Clean("/" + p)
+		endpoint.trustedProxies = trustedProxies
 		err = d.addEndpoint(cleanPath, endpoint, config.DataSources)
 		if err != nil {
 			return fmt.Errorf("unable to load template endpoint %s: %v", p, err)
@@ -162,25 +198,50 @@ func (d *DistroMux) load() error {
 		}
 	}
 
+	for p, endpoint := range config.Endpoints.IPXE {
+		cleanPath := path.Clean("/" + p)
+		err = d.addEndpoint(cleanPath, endpoint, config.DataSources)
+		if err != nil {
+			return fmt.Errorf("unable to load ipxe endpoint %s: %v", p, err)
+		}
+	}
+
 	return nil
 }
 
-func (d *DistroMux) Test() (map[string]*DistroTestResult, error) {
-	testConfig := d.cfg.Test
-	testsFolder := testConfig.Folder
+// testsPath returns the path to the folder containing the test cases for this DistroMux.
+func (d *DistroMux) testsPath() string {
+	testsFolder := d.cfg.Test.Folder
 	if testsFolder == "" {
 		testsFolder = "tests"
 	}
+	return path.Join(d.basePath, testsFolder)
+}
+
+// HasTests returns true if the tests folder for this DistroMux exists.
+func (d *DistroMux) HasTests() bool {
+	info, err := os.Stat(d.testsPath())
+	return err == nil && info.IsDir()
+}
 
+// Test runs all test cases found in the tests folder against this DistroMux's configuration.  Each test case
+// is run against a separately loaded copy of the distro, so that mocked datasource responses never end up in
+// the caches of the datasources this DistroMux serves, or of those used by another test case.
+func (d *DistroMux) Test() (map[string]*DistroTestResult, error) {
 	// Load test cases from folder
-	testCases, err := LoadTestCases(path.Join(d.basePath, testsFolder))
+	testCases, err := LoadTestCases(d.testsPath())
 	if err != nil {
 		return nil, fmt.Errorf("failed loading test cases from file: %v", err)
 	}
 
 	testResults := make(map[string]*DistroTestResult)
 	for p, c := range testCases {
-		testResults[p] = c.Test(d, d.cfg.DataSources)
+		testMux, err := NewDistroMux(d.basePath, mux.NewRouter())
+		if err != nil {
+			testResults[p] = &DistroTestResult{Failed: true, Output: fmt.Sprintf("unable to load distro for test: %v", err)}
+			continue
+		}
+		testResults[p] = c.Test(testMux, testMux.cfg.DataSources)
 	}
 
 	return testResults, nil
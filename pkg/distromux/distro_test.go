@@ -1,10 +1,16 @@
 package distromux
 
 import (
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gorilla/mux"
+	gock "gopkg.in/h2non/gock.v1"
 )
 
 func TestDistroMux(t *testing.T) {
@@ -40,6 +46,10 @@ func TestDistroMux(t *testing.T) {
 	if _, ok := routes["/google/"]; !ok {
 		t.Fatalf("google proxy endpoint not created")
 	}
+
+	if _, ok := routes["/ipxe"]; !ok {
+		t.Fatalf("ipxe endpoint not created")
+	}
 }
 
 func TestDistroMuxTest(t *testing.T) {
@@ -67,3 +77,60 @@ func TestDistroMuxTest(t *testing.T) {
 		}
 	}
 }
+
+func TestDistroMuxTestIsolatesCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "distromux")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config.yml": "endpoints:\n  template:\n    role:\n      template_path: role\n      default_template: default.tmpl\n" +
+			"datasources:\n  node:\n    url: https://api.local/v1/node\n    method: GET\n    cache:\n      ttl: 1h\n" +
+			"test:\n  folder: tests\n",
+		"role/default.tmpl": `{{ (api "node" "" "" "").Data.role }}`,
+	}
+	for _, role := range []string{"compute", "storage"} {
+		files["tests/"+role+".yml"] = "request:\n  path: /role\n  method: GET\n" +
+			"mocked_data:\n  - datasource: node\n    request:\n      method: GET\n    response:\n      status: 200\n      body: '{\"role\": \"" + role + "\"}'\n" +
+			"expected:\n  status: 200\n  body: " + role + "\n"
+	}
+	for name, contents := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %v", name, err)
+		}
+	}
+
+	m, err := NewDistroMux(dir, mux.NewRouter())
+	if err != nil {
+		t.Fatalf("Error creating distromux: %v", err)
+	}
+
+	results, err := m.Test()
+	if err != nil {
+		t.Fatalf("distromux tests errored: %v", err)
+	}
+	for p, r := range results {
+		if r.Failed {
+			t.Errorf("Test %s failed: %s", p, r.Output)
+		}
+	}
+
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off() // Flush pending mocks after test execution
+
+	gock.New("https://api.local/v1").
+		Get("/node").
+		Reply(200).
+		JSON(map[string]string{"role": "live"})
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "http://local/role", nil)
+	m.ServeHTTP(response, request)
+	if body := response.Body.String(); body != "live" {
+		t.Errorf("mocked test data served after the tests ran: %q", body)
+	}
+}
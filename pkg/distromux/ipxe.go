@@ -0,0 +1,330 @@
+package distromux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	"github.com/gorilla/mux"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+)
+
+const (
+	defaultIPXERoleField   = "Role"
+	defaultIPXEErrorScript = "echo Boot failed, rebooting in 30 seconds\nsleep 30\nreboot"
+)
+
+var (
+	// ipxeRequestParams are the request parameters understood by an IPXEEndpoint.
+	ipxeRequestParams = []string{"mac", "uuid", "serial", "buildarch", "platform"}
+
+	// defaultIPXELookupParams are passed to the datasource if no lookup_params are configured.
+	defaultIPXELookupParams = []string{"mac", "uuid", "serial"}
+)
+
+// IPXEBootConfig describes the kernel, initrds and kernel command line used to boot a node.  Each
+// value is a golang template rendered with IPXEData.
+type IPXEBootConfig struct {
+	Kernel  string   `mapstructure:"kernel"`
+	Initrd  []string `mapstructure:"initrd"`
+	Cmdline string   `mapstructure:"cmdline"`
+}
+
+// IPXEEndpoint describes the configuration of an endpoint that renders an iPXE boot script for
+// the node making the request.  The node is looked up in DataSource using the iPXE request
+// parameters listed in LookupParams, and the value found at RoleField in the response selects
+// the boot configuration from Roles.
+type IPXEEndpoint struct {
+	DataSource       string                     `mapstructure:"datasource"`
+	LookupParams     []string                   `mapstructure:"lookup_params"`
+	RoleField        string                     `mapstructure:"role_field"`
+	Roles            map[string]*IPXEBootConfig `mapstructure:"roles"`
+	DefaultRole      string                     `mapstructure:"default_role"`
+	Chain            string                     `mapstructure:"chain"`
+	ErrorScript      string                     `mapstructure:"error_script"`
+	RedirectInsecure bool                       `mapstructure:"redirect_insecure"`
+	RouteConfig      `mapstructure:",squash"`
+}
+
+// IPXEData is passed into the boot config templates at render time.
+type IPXEData struct {
+	BaseURL    string
+	DistroVars DistroVars
+	Params     map[string]string
+	Node       interface{}
+	Role       string
+}
+
+// ipxeBootTemplates holds the parsed templates for a single IPXEBootConfig.
+type ipxeBootTemplates struct {
+	kernel  *template.Template
+	initrd  []*template.Template
+	cmdline *template.Template
+}
+
+// IPXEHandler renders iPXE scripts for an IPXEEndpoint.
+type IPXEHandler struct {
+	endpoint    *IPXEEndpoint
+	dataSources api.EndpointMap
+	roles       map[string]*ipxeBootTemplates
+	chain       *template.Template
+	errorScript string
+}
+
+// CreateHandler returns a handler that renders iPXE scripts for the endpoint described by this configuration.
+func (e *IPXEEndpoint) CreateHandler(_ string, _ string, dataSources api.EndpointMap) (http.Handler, error) {
+	if e.DataSource != "" {
+		if _, ok := dataSources[e.DataSource]; !ok {
+			return nil, fmt.Errorf("datasource not found: %s", e.DataSource)
+		}
+	}
+
+	if e.DefaultRole != "" {
+		if _, ok := e.Roles[e.DefaultRole]; !ok {
+			return nil, fmt.Errorf("default role %s has no boot configuration", e.DefaultRole)
+		}
+	}
+
+	ih := &IPXEHandler{endpoint: e, dataSources: dataSources, roles: make(map[string]*ipxeBootTemplates)}
+	for role, cfg := range e.Roles {
+		bootTemplates, err := parseIPXEBootConfig(role, cfg)
+		if err != nil {
+			return nil, err
+		}
+		ih.roles[role] = bootTemplates
+	}
+
+	if e.Chain != "" {
+		chain, err := parseIPXETemplate("chain", e.Chain)
+		if err != nil {
+			return nil, err
+		}
+		ih.chain = chain
+	}
+
+	ih.errorScript = e.ErrorScript
+	if ih.errorScript == "" {
+		ih.errorScript = defaultIPXEErrorScript
+	}
+
+	var h http.Handler = ih
+	if e.RedirectInsecure {
+		h = RedirectInsecure(h)
+	}
+
+	return h, nil
+}
+
+func parseIPXETemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(sprig.TxtFuncMap()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s template: %v", name, err)
+	}
+	return t, nil
+}
+
+func parseIPXEBootConfig(role string, cfg *IPXEBootConfig) (*ipxeBootTemplates, error) {
+	if cfg == nil || cfg.Kernel == "" {
+		return nil, fmt.Errorf("no kernel configured for role %s", role)
+	}
+
+	var err error
+	bt := &ipxeBootTemplates{}
+	bt.kernel, err = parseIPXETemplate(role+".kernel", cfg.Kernel)
+	if err != nil {
+		return nil, err
+	}
+
+	bt.cmdline, err = parseIPXETemplate(role+".cmdline", cfg.Cmdline)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, initrd := range cfg.Initrd {
+		t, err := parseIPXETemplate(fmt.Sprintf("%s.initrd.%d", role, i), initrd)
+		if err != nil {
+			return nil, err
+		}
+		bt.initrd = append(bt.initrd, t)
+	}
+	return bt, nil
+}
+
+func renderIPXETemplate(t *template.Template, data *IPXEData) (string, error) {
+	wr := bytes.NewBufferString("")
+	err := t.Execute(wr, data)
+	return strings.TrimSpace(wr.String()), err
+}
+
+// lookupField returns the value found by following the dot separated field path through data.
+func lookupField(data interface{}, field string) (interface{}, bool) {
+	current := data
+	for _, key := range strings.Split(field, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// lookupNode queries the configured datasource for the node making the request.  A nil node is
+// returned if the datasource doesn't know about the node.
+func (h *IPXEHandler) lookupNode(ctx context.Context, params map[string]string) (interface{}, error) {
+	if h.endpoint.DataSource == "" {
+		return nil, nil
+	}
+
+	lookupParams := h.endpoint.LookupParams
+	if len(lookupParams) == 0 {
+		lookupParams = defaultIPXELookupParams
+	}
+
+	query := url.Values{}
+	for _, p := range lookupParams {
+		if v, ok := params[p]; ok && v != "" {
+			query.Set(p, v)
+		}
+	}
+
+	if len(query) == 0 {
+		return nil, fmt.Errorf("none of the lookup parameters %v were supplied", lookupParams)
+	}
+
+	response, err := h.dataSources.CallContext(ctx, h.endpoint.DataSource, "", query.Encode(), "")
+	if response != nil && response.Status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.Status != http.StatusOK {
+		return nil, fmt.Errorf("datasource %s returned status %d", h.endpoint.DataSource, response.Status)
+	}
+	return response.Data, nil
+}
+
+// selectRole returns the name of the role used to boot node.
+func (h *IPXEHandler) selectRole(node interface{}) string {
+	roleField := h.endpoint.RoleField
+	if roleField == "" {
+		roleField = defaultIPXERoleField
+	}
+
+	if value, ok := lookupField(node, roleField); ok {
+		role := fmt.Sprintf("%v", value)
+		if _, ok := h.roles[role]; ok {
+			return role
+		}
+	}
+
+	return h.endpoint.DefaultRole
+}
+
+func (h *IPXEHandler) renderBootScript(bt *ipxeBootTemplates, data *IPXEData) (string, error) {
+	kernel, err := renderIPXETemplate(bt.kernel, data)
+	if err != nil {
+		return "", err
+	}
+
+	cmdline, err := renderIPXETemplate(bt.cmdline, data)
+	if err != nil {
+		return "", err
+	}
+
+	script := bytes.NewBufferString("#!ipxe\n")
+	fmt.Fprintf(script, "kernel %s", kernel)
+	if cmdline != "" {
+		fmt.Fprintf(script, " %s", cmdline)
+	}
+	fmt.Fprintf(script, " || goto error\n")
+
+	for _, t := range bt.initrd {
+		initrd, err := renderIPXETemplate(t, data)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(script, "initrd %s || goto error\n", initrd)
+	}
+
+	fmt.Fprintf(script, "boot || goto error\n")
+	fmt.Fprintf(script, ":error\n%s\n", h.errorScript)
+	return script.String(), nil
+}
+
+func (h *IPXEHandler) renderChainScript(data *IPXEData) (string, error) {
+	chain, err := renderIPXETemplate(h.chain, data)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("#!ipxe\nchain --autofree %s || goto error\n:error\n%s\n", chain, h.errorScript), nil
+}
+
+func (h *IPXEHandler) renderErrorScript(msg string) string {
+	return fmt.Sprintf("#!ipxe\necho %s\n%s\n", msg, h.errorScript)
+}
+
+func (h *IPXEHandler) script(r *http.Request) (string, error) {
+	distroVars, _ := DistroVarsFromContext(r.Context())
+
+	baseURL, err := requestBaseURL(r)
+	if err != nil {
+		return "", err
+	}
+
+	query := r.URL.Query()
+	vars := mux.Vars(r)
+	params := make(map[string]string)
+	for _, p := range ipxeRequestParams {
+		v := query.Get(p)
+		if v == "" {
+			v = vars[p]
+		}
+		if v != "" {
+			params[p] = v
+		}
+	}
+
+	data := &IPXEData{BaseURL: baseURL, DistroVars: distroVars, Params: params}
+	data.Node, err = h.lookupNode(r.Context(), params)
+	if err != nil {
+		return "", fmt.Errorf("unable to lookup node: %v", err)
+	}
+
+	data.Role = h.selectRole(data.Node)
+	if bt, ok := h.roles[data.Role]; ok {
+		return h.renderBootScript(bt, data)
+	}
+
+	if h.chain != nil {
+		return h.renderChainScript(data)
+	}
+
+	return h.renderErrorScript("No boot configuration found for this node"), nil
+}
+
+// ServeHTTP renders the iPXE script for the requesting node.  Errors are returned as an iPXE script
+// that runs the configured error script so that the node doesn't hang waiting for a valid response.
+func (h *IPXEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	script, err := h.script(r)
+	if err != nil {
+		log.Printf("An error ocurred while handling %v: %s", r, err)
+		script = h.renderErrorScript("An error ocurred while generating the boot script")
+	}
+
+	w.Header().Set("Content-type", "text/plain")
+	if _, err := w.Write([]byte(script)); err != nil {
+		log.Printf("Unable to write body to client: %s", err)
+	}
+}
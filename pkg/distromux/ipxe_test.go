@@ -0,0 +1,131 @@
+package distromux
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func testIPXEEndpoint() *IPXEEndpoint {
+	return &IPXEEndpoint{
+		DataSource: "node",
+		Roles: map[string]*IPXEBootConfig{
+			"worker": &IPXEBootConfig{
+				Kernel:  "{{ .BaseURL }}/images/{{ .Params.buildarch }}/vmlinuz",
+				Initrd:  []string{"{{ .BaseURL }}/images/{{ .Params.buildarch }}/initrd.img"},
+				Cmdline: "hostname={{ .Node.Hostname }} kube_version={{ index .DistroVars \"kube_version\" }}",
+			},
+		},
+		Chain:       "{{ .BaseURL }}/discover?mac={{ .Params.mac }}",
+		ErrorScript: "shell",
+	}
+}
+
+func renderIPXE(t *testing.T, e *IPXEEndpoint, dataSources api.EndpointMap, target string) (int, string) {
+	h, err := e.CreateHandler("", "", dataSources)
+	if err != nil {
+		t.Fatalf("unable to create ipxe handler: %v", err)
+	}
+
+	request, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatalf("unable to create test request: %v", err)
+	}
+	request = DistroVars{"kube_version": "1.9.0"}.SetContextForRequest(request)
+
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	body, err := ioutil.ReadAll(response.Result().Body)
+	if err != nil {
+		t.Fatalf("unable to read response body: %v", err)
+	}
+
+	if contentType := response.Result().Header.Get("Content-type"); contentType != "text/plain" {
+		t.Errorf("wrong content type returned: %s", contentType)
+	}
+	return response.Result().StatusCode, string(body)
+}
+
+func checkIPXEScript(t *testing.T, expected, got string) {
+	if got != expected {
+		dmp := diffmatchpatch.New()
+		t.Errorf("rendered script doesn't match expected:\n%s", dmp.DiffPrettyText(dmp.DiffMain(expected, got, false)))
+	}
+}
+
+func TestIPXEBootScript(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off()
+
+	gock.New("https://api.local/v1").
+		Get("/node").
+		MatchParam("mac", "00:11:22:33:44:55").
+		Reply(200).
+		JSON(map[string]string{"Role": "worker", "Hostname": "pgc-0030"})
+
+	dataSources := api.EndpointMap{"node": &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet}}
+	status, body := renderIPXE(t, testIPXEEndpoint(), dataSources, "http://boot.local/branch/master/ipxe?mac=00:11:22:33:44:55&buildarch=x86_64")
+	if status != http.StatusOK {
+		t.Errorf("got wrong status: %d", status)
+	}
+
+	checkIPXEScript(t, `#!ipxe
+kernel http://boot.local/branch/master/images/x86_64/vmlinuz hostname=pgc-0030 kube_version=1.9.0 || goto error
+initrd http://boot.local/branch/master/images/x86_64/initrd.img || goto error
+boot || goto error
+:error
+shell
+`, body)
+}
+
+func TestIPXEChainUnknownNode(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off()
+
+	gock.New("https://api.local/v1").
+		Get("/node").
+		Reply(404).
+		JSON(map[string]string{"msg": "not found"})
+
+	dataSources := api.EndpointMap{"node": &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet}}
+	_, body := renderIPXE(t, testIPXEEndpoint(), dataSources, "http://boot.local/branch/master/ipxe?mac=00:11:22:33:44:55")
+
+	checkIPXEScript(t, `#!ipxe
+chain --autofree http://boot.local/branch/master/discover?mac=00:11:22:33:44:55 || goto error
+:error
+shell
+`, body)
+}
+
+func TestIPXEErrorScript(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off()
+
+	gock.New("https://api.local/v1").
+		Get("/node").
+		Reply(500).
+		JSON(map[string]string{"msg": "internal error"})
+
+	dataSources := api.EndpointMap{"node": &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet}}
+	_, body := renderIPXE(t, testIPXEEndpoint(), dataSources, "http://boot.local/branch/master/ipxe?mac=00:11:22:33:44:55")
+
+	checkIPXEScript(t, `#!ipxe
+echo An error ocurred while generating the boot script
+shell
+`, body)
+}
+
+func TestIPXEMissingDataSource(t *testing.T) {
+	_, err := testIPXEEndpoint().CreateHandler("", "", api.EndpointMap{})
+	if err == nil {
+		t.Errorf("expected error creating handler with missing datasource")
+	}
+}
@@ -0,0 +1,75 @@
+package distromux
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	shlex "github.com/anmitsu/go-shlex"
+	"github.com/mitchellh/mapstructure"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/handler/pipe"
+)
+
+// PostRenderStep is a command the rendered template is piped through.  In the config a step is either a
+// string, split into the command and its arguments using shell quoting rules, or a map with the fields below.
+// ContentType is the content type of the command's output, defaulting to the endpoint's content_type.  Env
+// and Timeout are added to, and override, the endpoint's post_render_options.
+//
+// A map may name one of the native transforms in the pipe package, such as yaml2json, json2yaml, jsonmerge,
+// gzip, base64 and ct, as Transform instead of giving a Command.  The transform is run in process with Args,
+// ignoring Env and Timeout.  Commands are always executed, even if they share a transform's name.
+type PostRenderStep struct {
+	Command     string        `mapstructure:"command"`
+	Transform   string        `mapstructure:"transform"`
+	Args        []string      `mapstructure:"args"`
+	ContentType string        `mapstructure:"content_type"`
+	Env         []string      `mapstructure:"env"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
+// ParsePostRenderStep converts a post_render entry from the config into a PostRenderStep.
+func ParsePostRenderStep(value interface{}) (*PostRenderStep, error) {
+	var step *PostRenderStep
+	switch v := value.(type) {
+	case *PostRenderStep:
+		step = v
+	case string:
+		args, err := shlex.Split(v, true)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse post_render command '%s': %v", v, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty post_render command")
+		}
+		step = &PostRenderStep{Command: args[0], Args: args[1:]}
+	case map[string]interface{}, map[interface{}]interface{}:
+		step = &PostRenderStep{}
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+			ErrorUnused:      true,
+			WeaklyTypedInput: true,
+			Result:           step,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := decoder.Decode(v); err != nil {
+			return nil, fmt.Errorf("unable to parse post_render step: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("post_render step must be a string or a map, got %T", value)
+	}
+
+	switch {
+	case step.Transform != "" && step.Command != "":
+		return nil, fmt.Errorf("post_render step has both a command and a transform")
+	case step.Transform != "":
+		if !pipe.IsNative(step.Transform) {
+			return nil, fmt.Errorf("unknown post_render transform %s, expected one of %s", step.Transform, strings.Join(pipe.NativeNames(), ", "))
+		}
+	case strings.TrimSpace(step.Command) == "":
+		return nil, fmt.Errorf("post_render step has no command")
+	}
+	return step, nil
+}
@@ -0,0 +1,190 @@
+package distromux
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/handler/pipe"
+	templatehandler "github.com/PolarGeospatialCenter/pgcboot/pkg/handler/template"
+)
+
+func TestParsePostRenderStep(t *testing.T) {
+	cases := map[string]struct {
+		value    interface{}
+		expected *PostRenderStep
+	}{
+		"simple": {"/bin/cat", &PostRenderStep{Command: "/bin/cat", Args: []string{}}},
+		"quoted": {`ct  --platform "custom platform" --files-dir='my files'`,
+			&PostRenderStep{Command: "ct", Args: []string{"--platform", "custom platform", "--files-dir=my files"}}},
+		"map": {map[string]interface{}{"command": "ct", "args": []interface{}{"--strict"}, "content_type": "application/json", "env": []interface{}{"CT_DEBUG"}, "timeout": "5s"},
+			&PostRenderStep{Command: "ct", Args: []string{"--strict"}, ContentType: "application/json", Env: []string{"CT_DEBUG"}, Timeout: 5 * time.Second}},
+		"yaml map": {map[interface{}]interface{}{"command": "gzip"},
+			&PostRenderStep{Command: "gzip"}},
+		"transform": {map[interface{}]interface{}{"transform": "base64", "args": []interface{}{"-d"}},
+			&PostRenderStep{Transform: "base64", Args: []string{"-d"}}},
+	}
+
+	for name, c := range cases {
+		step, err := ParsePostRenderStep(c.value)
+		if err != nil {
+			t.Errorf("%s: unable to parse step: %v", name, err)
+			continue
+		}
+		if diff := deep.Equal(step, c.expected); diff != nil {
+			t.Errorf("%s: wrong step parsed: %v", name, diff)
+		}
+	}
+}
+
+func TestParsePostRenderStepInvalid(t *testing.T) {
+	cases := map[string]interface{}{
+		"empty":                 "   ",
+		"unclosed quote":        `ct "--platform`,
+		"no command":            map[string]interface{}{"args": []interface{}{"-d"}},
+		"unknown field":         map[string]interface{}{"command": "ct", "cmd": "ct"},
+		"invalid timeout":       map[string]interface{}{"command": "ct", "timeout": "soon"},
+		"wrong type":            42,
+		"unknown transform":     map[string]interface{}{"transform": "xml2json"},
+		"command and transform": map[string]interface{}{"command": "/usr/bin/ct", "transform": "ct"},
+	}
+
+	for name, value := range cases {
+		if _, err := ParsePostRenderStep(value); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTemplatePostRenderSteps(t *testing.T) {
+	ep := &TemplateEndpoint{
+		TemplatePath:    "foo",
+		ContentType:     "application/json",
+		DefaultTemplate: "default.tmpl.yml",
+		PostRender: []interface{}{
+			`/bin/sh -c "tr 'a-z' 'A-Z'"`,
+			map[interface{}]interface{}{"command": "/bin/sh", "args": []interface{}{"-c", "cat; echo \"$STEP_VAR\""}, "content_type": "text/plain", "env": []interface{}{"STEP_VAR=done"}},
+		},
+	}
+	handler, err := ep.CreateHandler("../../test/data/branch/basic", "", api.EndpointMap{})
+	if err != nil {
+		t.Fatalf("unable to load endpoint for testing: %v", err)
+	}
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "https://test.local/branch/dev/foo", nil)
+	handler.ServeHTTP(response, request.WithContext(NewDistroVarsContext(request.Context(), DistroVars{})))
+
+	body, _ := ioutil.ReadAll(response.Result().Body)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Got non-OK status %d: %s", response.Code, body)
+	}
+
+	if contentType := response.Header().Get("Content-type"); contentType != "text/plain" {
+		t.Errorf("Content type of the last step not used: %s", contentType)
+	}
+
+	if len(body) < 5 || string(body[len(body)-5:]) != "done\n" {
+		t.Errorf("Step environment not applied: %q", body)
+	}
+}
+
+func TestTemplatePostRenderInvalid(t *testing.T) {
+	ep := &TemplateEndpoint{TemplatePath: "foo", DefaultTemplate: "default.tmpl.yml", PostRender: []interface{}{`ct "unterminated`}}
+	if _, err := ep.CreateHandler("../../test/data/branch/basic", "", api.EndpointMap{}); err == nil {
+		t.Errorf("Expected an error creating an endpoint with an invalid post_render step")
+	}
+}
+
+func TestTemplatePostRenderNative(t *testing.T) {
+	dir, err := ioutil.TempDir("", "postrender")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "default.tmpl.yml"), []byte("method: {{ .Method }}\n"), 0644); err != nil {
+		t.Fatalf("Unable to write template: %v", err)
+	}
+
+	ep := &TemplateEndpoint{TemplatePath: ".", ContentType: "text/plain", DefaultTemplate: "default.tmpl.yml", PostRender: []interface{}{
+		map[string]interface{}{"transform": "yaml2json"},
+		map[string]interface{}{"transform": "base64", "args": []interface{}{"-w0"}},
+	}}
+	handler, err := ep.CreateHandler(dir, "", api.EndpointMap{})
+	if err != nil {
+		t.Fatalf("unable to load endpoint for testing: %v", err)
+	}
+
+	step, ok := handler.(*pipe.PipeHandler)
+	if !ok {
+		t.Fatalf("post_render steps not added to the handler")
+	}
+	if _, ok := step.ResponsePipe.(*pipe.NativePipe); !ok {
+		t.Errorf("Native transform not used for base64: %T", step.ResponsePipe)
+	}
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "https://test.local/branch/dev/foo", nil)
+	handler.ServeHTTP(response, request.WithContext(NewDistroVarsContext(request.Context(), DistroVars{})))
+
+	body, _ := ioutil.ReadAll(response.Result().Body)
+	decoded, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		t.Fatalf("Response isn't base64 encoded: %q", body)
+	}
+
+	var value map[string]string
+	if err := json.Unmarshal(decoded, &value); err != nil || value["method"] != "GET" {
+		t.Errorf("yaml2json didn't produce the expected json: %q", decoded)
+	}
+
+	if contentType := response.Header().Get("Content-type"); contentType != "text/plain" {
+		t.Errorf("Wrong content type: %s", contentType)
+	}
+}
+
+func TestTemplatePostRenderCommandNamedLikeTransform(t *testing.T) {
+	for _, command := range []string{"/usr/bin/gzip", "gzip", "ct --platform=ec2"} {
+		ep := &TemplateEndpoint{TemplatePath: "foo", DefaultTemplate: "default.tmpl.yml", PostRender: []interface{}{command}}
+		handler, err := ep.CreateHandler("../../test/data/branch/basic", "", api.EndpointMap{})
+		if err != nil {
+			t.Fatalf("%s: unable to load endpoint for testing: %v", command, err)
+		}
+
+		if _, ok := handler.(*pipe.PipeHandler).ResponsePipe.(*pipe.PipeExec); !ok {
+			t.Errorf("%s: command should be executed", command)
+		}
+	}
+}
+
+func TestTemplatePostRenderStream(t *testing.T) {
+	ep := &TemplateEndpoint{TemplatePath: "foo", DefaultTemplate: "default.tmpl.yml", ContentType: "text/plain", Stream: true, PostRender: []interface{}{map[string]interface{}{"transform": "base64"}, "/bin/cat"}}
+	handler, err := ep.CreateHandler("../../test/data/branch/basic", "", api.EndpointMap{})
+	if err != nil {
+		t.Fatalf("unable to load endpoint for testing: %v", err)
+	}
+
+	outer := handler.(*pipe.PipeHandler)
+	inner := outer.Handler.(*pipe.PipeHandler)
+	if !outer.Stream || !inner.Stream || !inner.Handler.(*templatehandler.TemplateHandler).Stream {
+		t.Errorf("Stream not enabled for every handler in the chain")
+	}
+
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "https://test.local/branch/dev/foo", nil)
+	handler.ServeHTTP(response, request.WithContext(NewDistroVarsContext(request.Context(), DistroVars{})))
+
+	if response.Code != http.StatusOK {
+		t.Errorf("Got non-OK status: %d", response.Code)
+	}
+}
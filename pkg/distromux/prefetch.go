@@ -0,0 +1,118 @@
+package distromux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+	templatehandler "github.com/PolarGeospatialCenter/pgcboot/pkg/handler/template"
+)
+
+// prefetchCall is a prefetch call with its path, query and body parsed as templates.
+type prefetchCall struct {
+	dataSource string
+	path       *template.Template
+	query      *template.Template
+	body       *template.Template
+}
+
+// compilePrefetch checks that each prefetch call refers to an existing datasource and parses its fields as
+// templates.
+func compilePrefetch(prefetch map[string]*api.CallSpec, dataSources api.EndpointMap) (map[string]*prefetchCall, error) {
+	calls := make(map[string]*prefetchCall, len(prefetch))
+	for name, call := range prefetch {
+		if _, ok := dataSources[call.DataSource]; !ok {
+			return nil, fmt.Errorf("prefetch %s refers to missing datasource: %s", name, call.DataSource)
+		}
+
+		compiled := &prefetchCall{dataSource: call.DataSource}
+		var err error
+		if compiled.path, err = parsePrefetchField(name, "path", call.Path); err != nil {
+			return nil, err
+		}
+		if compiled.query, err = parsePrefetchField(name, "query", call.Query); err != nil {
+			return nil, err
+		}
+		if compiled.body, err = parsePrefetchField(name, "body", call.Body); err != nil {
+			return nil, err
+		}
+		calls[name] = compiled
+	}
+	return calls, nil
+}
+
+// parsePrefetchField parses the named field of a prefetch call as a template.
+func parsePrefetchField(name, field, value string) (*template.Template, error) {
+	t, err := template.New(field).Funcs(sprig.TxtFuncMap()).Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("prefetch %s has an invalid %s template: %v", name, field, err)
+	}
+	return t, nil
+}
+
+// renderPrefetchField renders t against data.
+func renderPrefetchField(t *template.Template, data *TemplateData) (string, error) {
+	var out bytes.Buffer
+	err := t.Execute(&out, data)
+	return out.String(), err
+}
+
+// compiledPrefetch returns the parsed Prefetch calls.  They're parsed the first time they're needed, normally
+// when the handler is created.
+func (tr *TemplateRenderer) compiledPrefetch() (map[string]*prefetchCall, error) {
+	tr.prefetchOnce.Do(func() {
+		tr.prefetchCalls, tr.prefetchErr = compilePrefetch(tr.Prefetch, tr.DataSources)
+	})
+	return tr.prefetchCalls, tr.prefetchErr
+}
+
+// prefetch renders the path, query and body of each Prefetch call against data, then makes the calls
+// concurrently.  The results are stored in data.Prefetch.  Failed calls are left for the template to handle,
+// unless the error should be reported to the client, like an invalid response from the datasource.
+func (tr *TemplateRenderer) prefetch(r *http.Request, data *TemplateData) error {
+	if len(tr.Prefetch) == 0 {
+		return nil
+	}
+
+	compiled, err := tr.compiledPrefetch()
+	if err != nil {
+		return err
+	}
+
+	calls := make(map[string]*api.CallSpec, len(compiled))
+	for name, call := range compiled {
+		rendered := &api.CallSpec{DataSource: call.dataSource}
+		if rendered.Path, err = renderPrefetchField(call.path, data); err != nil {
+			return fmt.Errorf("unable to render path for prefetch %s: %v", name, err)
+		}
+		if rendered.Query, err = renderPrefetchField(call.query, data); err != nil {
+			return fmt.Errorf("unable to render query for prefetch %s: %v", name, err)
+		}
+		if rendered.Body, err = renderPrefetchField(call.body, data); err != nil {
+			return fmt.Errorf("unable to render body for prefetch %s: %v", name, err)
+		}
+		calls[name] = rendered
+	}
+
+	data.Prefetch = tr.DataSources.CallAll(r.Context(), calls)
+
+	names := make([]string, 0, len(data.Prefetch))
+	for name := range data.Prefetch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var responseErr templatehandler.ResponseError
+		if err := data.Prefetch[name].Err(); errors.As(err, &responseErr) {
+			return fmt.Errorf("prefetch %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package distromux
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func TestTemplatePrefetch(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off()
+
+	gock.New("https://api.local/v1").
+		Get("/node/pgc-0030").
+		Reply(200).
+		JSON(map[string]string{"Role": "worker"})
+	gock.New("https://api.local/v1").
+		Post("/network").
+		BodyString(`{"node": "pgc-0030"}`).
+		Reply(200).
+		JSON(map[string]string{"IP": "10.0.0.30"})
+
+	dataSources := api.EndpointMap{
+		"node":    &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet},
+		"network": &api.Endpoint{URL: "https://api.local/v1/network", Method: http.MethodPost},
+	}
+	prefetch := map[string]*api.CallSpec{
+		"node":    {DataSource: "node", Path: "/{{ .RequestParams.id }}"},
+		"network": {DataSource: "network", Body: `{"node": "{{ .RequestParams.id }}"}`},
+	}
+
+	if _, err := compilePrefetch(prefetch, dataSources); err != nil {
+		t.Fatalf("valid prefetch config rejected: %v", err)
+	}
+
+	r, err := http.NewRequest(http.MethodGet, "http://local/foo?id=pgc-0030", nil)
+	if err != nil {
+		t.Fatalf("unable to create request: %v", err)
+	}
+	r = DistroVars{}.SetContextForRequest(r)
+
+	renderer := &TemplateRenderer{DataSources: dataSources, Prefetch: prefetch}
+	data, err := renderer.GetData(r)
+	if err != nil {
+		t.Fatalf("unable to get template data: %v", err)
+	}
+
+	results := data.(*TemplateData).Prefetch
+	for name, expected := range map[string][2]string{"node": {"Role", "worker"}, "network": {"IP", "10.0.0.30"}} {
+		result, ok := results[name]
+		if !ok {
+			t.Errorf("no result for prefetch %s", name)
+			continue
+		}
+
+		if result.Error != "" {
+			t.Errorf("prefetch %s failed: %s", name, result.Error)
+			continue
+		}
+
+		if value := result.Response.Data.(map[string]interface{})[expected[0]]; value != expected[1] {
+			t.Errorf("wrong value for prefetch %s: %v", name, value)
+		}
+	}
+}
+
+func TestCompilePrefetch(t *testing.T) {
+	dataSources := api.EndpointMap{"node": &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet}}
+	invalid := map[string]*api.CallSpec{
+		"missing datasource": {DataSource: "network"},
+		"invalid template":   {DataSource: "node", Path: "/{{ .RequestParams.id "},
+	}
+
+	for name, call := range invalid {
+		if _, err := compilePrefetch(map[string]*api.CallSpec{name: call}, dataSources); err == nil {
+			t.Errorf("%s: expected an error validating prefetch", name)
+		}
+	}
+}
+
+func TestTemplatePrefetchSchemaError(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off() // Flush pending mocks after test execution
+
+	gock.New("https://api.local/v1").
+		Get("/node/pgc-0030").
+		Reply(200).
+		JSON(map[string]string{"Role": "worker"})
+
+	dataSources := api.EndpointMap{
+		"node": &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet, Schema: "type: object\nrequired: [IP]\n"},
+	}
+	prefetch := map[string]*api.CallSpec{"node": {DataSource: "node", Path: "/{{ .RequestParams.id }}"}}
+
+	r, err := http.NewRequest(http.MethodGet, "http://local/foo?id=pgc-0030", nil)
+	if err != nil {
+		t.Fatalf("unable to create request: %v", err)
+	}
+	r = DistroVars{}.SetContextForRequest(r)
+
+	renderer := &TemplateRenderer{DataSources: dataSources, Prefetch: prefetch}
+	_, err = renderer.GetData(r)
+	var schemaErr *api.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Errorf("expected a schema error from the prefetch, got %v", err)
+	}
+}
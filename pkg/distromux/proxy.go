@@ -1,38 +1,85 @@
 package distromux
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
 
 	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+	templatehandler "github.com/PolarGeospatialCenter/pgcboot/pkg/handler/template"
 )
 
-// ProxyEndpoint acts as a reverse proxy to the given TargetURL
+// ProxyEndpoint acts as a reverse proxy to the given TargetURL.  Route variables, written as {name}, in
+// TargetURL are replaced with their escaped values from the request.  If the expanded URL isn't valid the
+// request fails with a 502.
 type ProxyEndpoint struct {
 	TargetURL        string
 	RedirectInsecure bool `mapstructure:"redirect_insecure"`
+	RouteConfig      `mapstructure:",squash"`
 }
 
 // CreateHandler returns a httputil.ReverseProxy handler
 func (e *ProxyEndpoint) CreateHandler(_ string, pathPrefix string, _ api.EndpointMap) (http.Handler, error) {
-	u, err := url.Parse(e.TargetURL)
+	target, err := url.Parse(e.TargetURL)
 	if err != nil {
 		return nil, err
 	}
 	proxy := &httputil.ReverseProxy{
 		Director: func(r *http.Request) {
+			u, ok := r.Context().Value(proxyTargetContextKey).(*url.URL)
+			if !ok {
+				u = target
+			}
 			r.URL.Host = u.Host
 			r.URL.Scheme = u.Scheme
+			r.URL.RawPath = u.EscapedPath() + r.URL.EscapedPath()
 			r.URL.Path = u.Path + r.URL.Path
 			r.Host = u.Host
 			r.RequestURI = ""
 		}}
 
-	h := http.StripPrefix(pathPrefix, proxy)
+	var h http.Handler = proxy
+	if strings.Contains(e.TargetURL, "{") {
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, err := e.expandTarget(mux.Vars(r))
+			if err != nil {
+				templatehandler.RenderJsonError(w, http.StatusBadGateway, err)
+				return
+			}
+			proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), proxyTargetContextKey, u)))
+		})
+	}
+
+	h = stripRoutePrefix(pathPrefix, h)
 	if e.RedirectInsecure {
 		h = RedirectInsecure(h)
 	}
 
 	return h, nil
 }
+
+var proxyTargetContextKey = &contextKey{"proxy target"}
+
+// expandTarget returns TargetURL with the route variables replaced by their path escaped values.
+func (e *ProxyEndpoint) expandTarget(vars map[string]string) (*url.URL, error) {
+	escaped := make(map[string]string, len(vars))
+	for name, value := range vars {
+		escaped[name] = url.PathEscape(value)
+	}
+
+	expanded := expandVars(e.TargetURL, escaped)
+	if strings.Contains(expanded, "{") {
+		return nil, fmt.Errorf("unable to expand proxy target %s: unknown route variable", e.TargetURL)
+	}
+
+	u, err := url.Parse(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("unable to expand proxy target %s: %v", e.TargetURL, err)
+	}
+	return u, nil
+}
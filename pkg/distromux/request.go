@@ -0,0 +1,155 @@
+package distromux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	templatehandler "github.com/PolarGeospatialCenter/pgcboot/pkg/handler/template"
+)
+
+// maxRequestBodySize limits the size of request bodies parsed into TemplateData.
+const maxRequestBodySize = 1 << 20
+
+// parseTrustedProxies parses a list of addresses or CIDR ranges.  A bare address is treated as a single host.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: %s", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range: %v", err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// trustedProxy returns true if ip is in one of the trusted networks.
+func trustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client making the request.  X-Forwarded-For is only honored when the
+// request comes from a trusted proxy, in which case the list is walked from the right skipping trusted proxies,
+// so that a client can't choose the address reported by prepending to the header.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote := net.ParseIP(host)
+	if remote == nil || !trustedProxy(remote, trusted) {
+		return host
+	}
+
+	var forwarded []string
+	for _, value := range r.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+
+	client := remote
+	for i := len(forwarded) - 1; i >= 0 && trustedProxy(client, trusted); i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+	}
+	return client.String()
+}
+
+// selectHeaders returns the values of the named request headers.  Headers that weren't sent are omitted and
+// multiple values are joined with commas.
+func selectHeaders(r *http.Request, names []string) map[string]string {
+	headers := make(map[string]string)
+	for _, name := range names {
+		key := http.CanonicalHeaderKey(name)
+		if values, ok := r.Header[key]; ok {
+			headers[key] = strings.Join(values, ",")
+		}
+	}
+	return headers
+}
+
+// flattenValues converts url.Values into a map of strings, joining multiple values with commas.
+func flattenValues(values url.Values) map[string]string {
+	flat := make(map[string]string)
+	for key, value := range values {
+		switch len(value) {
+		case 1:
+			flat[key] = value[0]
+		case 0:
+		default:
+			flat[key] = strings.Join(value, ",")
+		}
+	}
+	return flat
+}
+
+// parseRequestBody parses a form encoded or JSON request body.  Form bodies are returned as a map of strings,
+// JSON bodies as the decoded value.  Requests without a body, or with any other content type, return nil.
+func parseRequestBody(r *http.Request) (interface{}, error) {
+	if r.Body == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestBodySize+1))
+	if err != nil {
+		return nil, templatehandler.ErrBadRequest{Message: fmt.Sprintf("unable to read request body: %v", err)}
+	}
+
+	if len(body) > maxRequestBodySize {
+		return nil, templatehandler.ErrRequestTooLarge{Message: fmt.Sprintf("request body exceeds the limit of %d bytes", maxRequestBodySize)}
+	}
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, templatehandler.ErrBadRequest{Message: fmt.Sprintf("unable to parse form body: %v", err)}
+		}
+		return flattenValues(values), nil
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if len(body) == 0 {
+			return nil, nil
+		}
+
+		var data interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, templatehandler.ErrBadRequest{Message: fmt.Sprintf("unable to parse json body: %v", err)}
+		}
+		return data, nil
+	default:
+		return nil, nil
+	}
+}
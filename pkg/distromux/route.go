@@ -0,0 +1,137 @@
+package distromux
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteConfig customizes how requests are matched to an endpoint.  By default an endpoint matches every
+// request whose path starts with the endpoint's key in the config.
+//
+// Route replaces the key with a gorilla/mux path template, such as /nodes/{id:[0-9]+}/ignition.  This is
+// needed for patterns containing characters that aren't valid in config keys, and preserves case.  If Exact
+// is set the whole path must match the template instead of a prefix.  MatchHeaders and MatchQueries are
+// lists of name=pattern pairs, where pattern is a regular expression for headers and a mux template for
+// queries.  Variables from the path, host and query templates are available to templates as PathVars.
+type RouteConfig struct {
+	Route        string   `mapstructure:"route"`
+	Exact        bool     `mapstructure:"exact"`
+	Methods      []string `mapstructure:"methods"`
+	Host         string   `mapstructure:"host"`
+	MatchHeaders []string `mapstructure:"match_headers"`
+	MatchQueries []string `mapstructure:"match_queries"`
+}
+
+// routedEndpoint is implemented by endpoints that embed a RouteConfig.
+type routedEndpoint interface {
+	routeConfig() *RouteConfig
+}
+
+func (c *RouteConfig) routeConfig() *RouteConfig {
+	return c
+}
+
+// splitPairs converts name=value strings into the alternating name, value list expected by mux.
+func splitPairs(kind string, pairs []string) ([]string, error) {
+	result := make([]string, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid %s matcher, expected name=pattern: %s", kind, pair)
+		}
+		result = append(result, parts[0], parts[1])
+	}
+	return result, nil
+}
+
+// apply configures route to match requests as described by c.  defaultPath is used if no Route is configured.
+func (c *RouteConfig) apply(route *mux.Route, defaultPath string) (*mux.Route, error) {
+	p := defaultPath
+	if c.Route != "" {
+		p = c.Route
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+	}
+
+	if c.Exact {
+		route = route.Path(p)
+	} else {
+		route = route.PathPrefix(p)
+	}
+
+	if len(c.Methods) > 0 {
+		methods := make([]string, len(c.Methods))
+		for i, m := range c.Methods {
+			methods[i] = strings.ToUpper(m)
+		}
+		route = route.Methods(methods...)
+	}
+
+	if c.Host != "" {
+		route = route.Host(c.Host)
+	}
+
+	if len(c.MatchHeaders) > 0 {
+		pairs, err := splitPairs("header", c.MatchHeaders)
+		if err != nil {
+			return nil, err
+		}
+		route = route.HeadersRegexp(pairs...)
+	}
+
+	if len(c.MatchQueries) > 0 {
+		pairs, err := splitPairs("query", c.MatchQueries)
+		if err != nil {
+			return nil, err
+		}
+		route = route.Queries(pairs...)
+	}
+
+	return route, route.GetError()
+}
+
+// stripRoutePrefix removes the part of the request path matched by the current route before calling h.  Path
+// templates without variables are handled by http.StripPrefix; otherwise the matched prefix is rebuilt from the
+// route's variables for each request.
+func stripRoutePrefix(pathPrefix string, h http.Handler) http.Handler {
+	if !strings.Contains(pathPrefix, "{") {
+		return http.StripPrefix(pathPrefix, h)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		pairs := make([]string, 0)
+		for name, value := range mux.Vars(r) {
+			pairs = append(pairs, name, value)
+		}
+
+		u, err := route.URLPath(pairs...)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		prefix := u.Path
+		if strings.HasSuffix(pathPrefix, "/") && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		http.StripPrefix(prefix, h).ServeHTTP(w, r)
+	})
+}
+
+// expandVars replaces {name} in s with the value of the route variable name.
+func expandVars(s string, vars map[string]string) string {
+	for name, value := range vars {
+		s = strings.Replace(s, "{"+name+"}", value, -1)
+	}
+	return s
+}
@@ -0,0 +1,234 @@
+package distromux
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func routeResponse(t *testing.T, h http.Handler, method, target string, header http.Header) (int, string) {
+	request := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	body, err := ioutil.ReadAll(response.Result().Body)
+	if err != nil {
+		t.Fatalf("Unable to read body: %v", err)
+	}
+	return response.Code, string(body)
+}
+
+func TestRouteConfigApply(t *testing.T) {
+	cfg := &RouteConfig{
+		Route:        "/nodes/{id:[0-9]+}",
+		Methods:      []string{"get", "post"},
+		Host:         "{site}.boot.local",
+		MatchHeaders: []string{"X-Node-Role=^(compute|storage)$"},
+		MatchQueries: []string{"arch={arch}"},
+	}
+
+	r := mux.NewRouter()
+	route, err := cfg.apply(r.NewRoute(), "/ignored/")
+	if err != nil {
+		t.Fatalf("Unable to apply route config: %v", err)
+	}
+	route.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		w.Write([]byte(vars["site"] + " " + vars["id"] + " " + vars["arch"]))
+	})
+
+	role := http.Header{"X-Node-Role": []string{"compute"}}
+	cases := []struct {
+		method string
+		target string
+		header http.Header
+		status int
+		body   string
+	}{
+		{"GET", "http://pgc.boot.local/nodes/42/ignition?arch=x86_64", role, http.StatusOK, "pgc 42 x86_64"},
+		{"POST", "http://pgc.boot.local/nodes/42?arch=arm64", role, http.StatusOK, "pgc 42 arm64"},
+		{"DELETE", "http://pgc.boot.local/nodes/42?arch=x86_64", role, http.StatusMethodNotAllowed, ""},
+		{"GET", "http://pgc.boot.local/nodes/abc?arch=x86_64", role, http.StatusNotFound, ""},
+		{"GET", "http://other.local/nodes/42?arch=x86_64", role, http.StatusNotFound, ""},
+		{"GET", "http://pgc.boot.local/nodes/42", role, http.StatusNotFound, ""},
+		{"GET", "http://pgc.boot.local/nodes/42?arch=x86_64", http.Header{"X-Node-Role": []string{"login"}}, http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		status, body := routeResponse(t, r, c.method, c.target, c.header)
+		if status != c.status {
+			t.Errorf("%s %s: got status %d, expected %d", c.method, c.target, status, c.status)
+			continue
+		}
+		if c.body != "" && body != c.body {
+			t.Errorf("%s %s: got body %q, expected %q", c.method, c.target, body, c.body)
+		}
+	}
+}
+
+func TestRouteConfigExact(t *testing.T) {
+	r := mux.NewRouter()
+	route, err := (&RouteConfig{Exact: true}).apply(r.NewRoute(), "/nodes")
+	if err != nil {
+		t.Fatalf("Unable to apply route config: %v", err)
+	}
+	route.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	if status, _ := routeResponse(t, r, "GET", "/nodes", nil); status != http.StatusOK {
+		t.Errorf("Exact route didn't match its path: got status %d", status)
+	}
+
+	if status, _ := routeResponse(t, r, "GET", "/nodes/42", nil); status != http.StatusNotFound {
+		t.Errorf("Exact route matched a longer path: got status %d", status)
+	}
+}
+
+func TestRouteConfigInvalid(t *testing.T) {
+	cases := map[string]*RouteConfig{
+		"header": {MatchHeaders: []string{"X-Node-Role"}},
+		"query":  {MatchQueries: []string{"=x"}},
+		"route":  {Route: "/nodes/{id"},
+	}
+
+	for name, cfg := range cases {
+		if _, err := cfg.apply(mux.NewRouter().NewRoute(), "/"); err == nil {
+			t.Errorf("Expected an error for invalid %s config", name)
+		}
+	}
+}
+
+func TestStaticEndpointRouteVars(t *testing.T) {
+	r := mux.NewRouter()
+	endpoint := &StaticEndpoint{SourcePath: "data/foo", RouteConfig: RouteConfig{Route: "/files/{site}/"}}
+	route, err := endpoint.apply(r.NewRoute(), "/")
+	if err != nil {
+		t.Fatalf("Unable to apply route config: %v", err)
+	}
+
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		t.Fatalf("Unable to get path template: %v", err)
+	}
+
+	h, err := endpoint.CreateHandler("../../test/data/branch/basic", tmpl, nil)
+	if err != nil {
+		t.Fatalf("Unable to create handler: %v", err)
+	}
+	route.Handler(h)
+
+	status, body := routeResponse(t, r, "GET", "/files/pgc/test.txt", nil)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d, expected 200: %s", status, body)
+	}
+}
+
+func TestProxyEndpointRouteVars(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off() // Flush pending mocks after test execution
+
+	gock.New("https://api.local").
+		Get("/v1/sites/pgc/nodes/foo").
+		Reply(200).
+		BodyString("proxied")
+
+	r := mux.NewRouter()
+	endpoint := &ProxyEndpoint{TargetURL: "https://api.local/v1/sites/{site}/", RouteConfig: RouteConfig{Route: "/proxy/{site}/"}}
+	route, err := endpoint.apply(r.NewRoute(), "/")
+	if err != nil {
+		t.Fatalf("Unable to apply route config: %v", err)
+	}
+
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		t.Fatalf("Unable to get path template: %v", err)
+	}
+
+	h, err := endpoint.CreateHandler("", tmpl, nil)
+	if err != nil {
+		t.Fatalf("Unable to create handler: %v", err)
+	}
+	route.Handler(h)
+
+	status, body := routeResponse(t, r, "GET", "/proxy/pgc/nodes/foo", nil)
+	if status != http.StatusOK || body != "proxied" {
+		t.Errorf("got %d %q, expected 200 \"proxied\"", status, body)
+	}
+}
+
+func TestProxyEndpointRouteVarsEscaped(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off() // Flush pending mocks after test execution
+
+	gock.New("https://api.local").
+		Get(`/v1/sites/a\?b c/nodes/foo`).
+		Reply(200).
+		BodyString("proxied")
+
+	r := mux.NewRouter()
+	endpoint := &ProxyEndpoint{TargetURL: "https://api.local/v1/sites/{site}/", RouteConfig: RouteConfig{Route: "/proxy/{site}/"}}
+	route, err := endpoint.apply(r.NewRoute(), "/")
+	if err != nil {
+		t.Fatalf("Unable to apply route config: %v", err)
+	}
+
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		t.Fatalf("Unable to get path template: %v", err)
+	}
+
+	h, err := endpoint.CreateHandler("", tmpl, nil)
+	if err != nil {
+		t.Fatalf("Unable to create handler: %v", err)
+	}
+	route.Handler(h)
+
+	status, body := routeResponse(t, r, "GET", "/proxy/a%3Fb%20c/nodes/foo", nil)
+	if status != http.StatusOK || body != "proxied" {
+		t.Errorf("got %d %q, expected 200 \"proxied\"", status, body)
+	}
+
+	u, err := endpoint.expandTarget(map[string]string{"site": "../admin?x=1"})
+	if err != nil {
+		t.Fatalf("Unable to expand target: %v", err)
+	}
+	if u.EscapedPath() != "/v1/sites/..%2Fadmin%3Fx=1/" {
+		t.Errorf("route variable not escaped: %s", u.EscapedPath())
+	}
+	if u.RawQuery != "" {
+		t.Errorf("route variable added a query: %s", u.RawQuery)
+	}
+}
+
+func TestProxyEndpointUnknownRouteVar(t *testing.T) {
+	r := mux.NewRouter()
+	endpoint := &ProxyEndpoint{TargetURL: "https://api.local/v1/sites/{site}/", RouteConfig: RouteConfig{Route: "/proxy/{name}/"}}
+	route, err := endpoint.apply(r.NewRoute(), "/")
+	if err != nil {
+		t.Fatalf("Unable to apply route config: %v", err)
+	}
+
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		t.Fatalf("Unable to get path template: %v", err)
+	}
+
+	h, err := endpoint.CreateHandler("", tmpl, nil)
+	if err != nil {
+		t.Fatalf("Unable to create handler: %v", err)
+	}
+	route.Handler(h)
+
+	status, _ := routeResponse(t, r, "GET", "/proxy/pgc/nodes/foo", nil)
+	if status != http.StatusBadGateway {
+		t.Errorf("got %d, expected 502 for an unknown route variable", status)
+	}
+}
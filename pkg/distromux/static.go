@@ -12,11 +12,12 @@ import (
 type StaticEndpoint struct {
 	SourcePath       string `mapstructure:"source"`
 	RedirectInsecure bool   `mapstructure:"redirect_insecure"`
+	RouteConfig      `mapstructure:",squash"`
 }
 
 // CreateHandler ceates a handler to serve the files found at basepath/SourcePath.
 func (e *StaticEndpoint) CreateHandler(basepath string, pathPrefix string, _ api.EndpointMap) (http.Handler, error) {
-	h := http.StripPrefix(pathPrefix, http.FileServer(http.Dir(filepath.Join(basepath, e.SourcePath))))
+	h := stripRoutePrefix(pathPrefix, http.FileServer(http.Dir(filepath.Join(basepath, e.SourcePath))))
 
 	if e.RedirectInsecure {
 		h = RedirectInsecure(h)
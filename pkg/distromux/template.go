@@ -9,21 +9,35 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/Masterminds/sprig"
+	"github.com/gorilla/mux"
 
 	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
 	"github.com/PolarGeospatialCenter/pgcboot/pkg/handler/pipe"
 	templatehandler "github.com/PolarGeospatialCenter/pgcboot/pkg/handler/template"
 )
 
-// TemplateData is the struct that will be passed into the template at render time
+// TemplateData is the struct that will be passed into the template at render time.  Headers only contains
+// the request headers listed in the endpoint's request_headers.  Body is the parsed request body: a map of
+// strings for form encoded bodies, the decoded value for JSON bodies, and nil otherwise.  RuleData holds the
+// responses from datasources called by template_rules while selecting the template, keyed by datasource, so
+// the template doesn't need to call them again.
 type TemplateData struct {
 	BaseURL       string
 	DistroVars    DistroVars
 	RequestParams map[string]string
 	RawQuery      string
+	Method        string
+	Headers       map[string]string
+	ClientIP      string
+	PathVars      map[string]string
+	Body          interface{}
+	Prefetch      map[string]*api.CallResult
+	RuleData      map[string]*api.APIResponse
 }
 
 // TemplateRenderer implements the RenderManager interface.
@@ -31,9 +45,22 @@ type TemplateRenderer struct {
 	DefaultTemplate  string
 	FileNameTemplate string
 	DataSources      api.EndpointMap
+	Rules            []*TemplateRule
+	Prefetch         map[string]*api.CallSpec
+	RequestHeaders   []string
+	TrustedProxies   []*net.IPNet
+
+	prefetchOnce  sync.Once
+	prefetchCalls map[string]*prefetchCall
+	prefetchErr   error
 }
 
 func (tr *TemplateRenderer) getBaseURL(r *http.Request) (string, error) {
+	return requestBaseURL(r)
+}
+
+// requestBaseURL returns the url of the folder containing the requested path.
+func requestBaseURL(r *http.Request) (string, error) {
 	relpath := ""
 	if r.URL.Path[0] == '/' {
 		pathels := strings.Split(r.URL.Path[1:], "/")
@@ -73,31 +100,74 @@ func (tr *TemplateRenderer) getTemplateData(r *http.Request) (*TemplateData, err
 		return nil, err
 	}
 
+	body, err := parseRequestBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pathVars := mux.Vars(r)
+	if pathVars == nil {
+		pathVars = make(map[string]string)
+	}
+
 	templateData := &TemplateData{
-		RawQuery:   r.URL.RawQuery,
-		DistroVars: distroVars,
-		BaseURL:    baseURL,
-	}
-	requestParams := make(map[string]string)
-	for key, value := range query {
-		switch len(value) {
-		case 1:
-			requestParams[key] = value[0]
-		case 0:
-		default:
-			requestParams[key] = strings.Join(value, ",")
-		}
+		RawQuery:      r.URL.RawQuery,
+		DistroVars:    distroVars,
+		BaseURL:       baseURL,
+		RequestParams: flattenValues(query),
+		Method:        r.Method,
+		Headers:       selectHeaders(r, tr.RequestHeaders),
+		ClientIP:      clientIP(r, tr.TrustedProxies),
+		PathVars:      pathVars,
+		Body:          body,
+	}
+
+	err = tr.prefetch(r, templateData)
+	if err != nil {
+		return nil, err
 	}
-	templateData.RequestParams = requestParams
 
 	return templateData, nil
 }
 
 // TemplateSelector chooses the appropriate template to use for handling the request.
 // Search order:
-// 1. node.Role match
+// 1. First matching entry in Rules
 // 2. DefaultTemplate
 func (tr *TemplateRenderer) TemplateSelector(r *http.Request, t *template.Template) (string, error) {
+	return tr.selectTemplate(r, make(map[string]*api.APIResponse))
+}
+
+// TemplateSelectorWithData is TemplateSelector storing the datasource responses used to select the template in
+// the RuleData of data.
+func (tr *TemplateRenderer) TemplateSelectorWithData(r *http.Request, t *template.Template, data interface{}) (string, error) {
+	templateData, ok := data.(*TemplateData)
+	if !ok {
+		return tr.TemplateSelector(r, t)
+	}
+
+	if templateData.RuleData == nil {
+		templateData.RuleData = make(map[string]*api.APIResponse)
+	}
+	return tr.selectTemplate(r, templateData.RuleData)
+}
+
+// selectTemplate returns the template of the first matching rule, or the default template.  Datasource
+// responses are stored in responses.
+func (tr *TemplateRenderer) selectTemplate(r *http.Request, responses map[string]*api.APIResponse) (string, error) {
+	for _, rule := range tr.Rules {
+		match, err := rule.Matches(r, tr.DataSources, responses)
+		if err != nil {
+			return "", err
+		}
+		if match {
+			return rule.Template, nil
+		}
+	}
+
+	if tr.DefaultTemplate == "" {
+		return "", templatehandler.ErrNotFound{Message: "No template matches the request"}
+	}
 	return tr.DefaultTemplate, nil
 }
 
@@ -117,11 +187,26 @@ func (tr *TemplateRenderer) GetData(r *http.Request) (interface{}, error) {
 func (tr *TemplateRenderer) TemplateFuncs() template.FuncMap {
 	fm := sprig.TxtFuncMap()
 	fm["api"] = tr.DataSources.Call
+	fm["apiBatch"] = tr.DataSources.Batch
 	fm["join"] = TemplateJoinWrapper
 	fm["applyCidrMask"] = TemplateNetworkCidrContains
 	return fm
 }
 
+// RequestFuncs returns the datasource template functions bound to the context of r, so that datasource calls
+// are cancelled with the request and appear in its trace.
+func (tr *TemplateRenderer) RequestFuncs(r *http.Request) template.FuncMap {
+	ctx := r.Context()
+	return template.FuncMap{
+		"api": func(endpoint, subPath, query, requestBody string) (*api.APIResponse, error) {
+			return tr.DataSources.CallContext(ctx, endpoint, subPath, query, requestBody)
+		},
+		"apiBatch": func(calls map[string]interface{}) (map[string]*api.CallResult, error) {
+			return tr.DataSources.BatchContext(ctx, calls)
+		},
+	}
+}
+
 func convertInterfaceToString(item interface{}) (string, error) {
 	switch item.(type) {
 	case string:
@@ -200,15 +285,81 @@ func TemplateNetworkCidrContains(cidr string, ips interface{}) ([]string, error)
 	return result, nil
 }
 
+// DefaultPostRenderTimeout is the time post_render commands may run if no timeout is configured.
+const DefaultPostRenderTimeout = 30 * time.Second
+
+// PostRenderOptions limits the commands run by post_render.  Env lists the server environment variables
+// passed to the commands, or NAME=value pairs.  Sizes are in bytes, zero means unlimited.
+type PostRenderOptions struct {
+	Timeout       time.Duration `mapstructure:"timeout"`
+	Env           []string      `mapstructure:"env"`
+	MaxOutputSize int64         `mapstructure:"max_output_size"`
+	MaxMemory     int64         `mapstructure:"max_memory"`
+}
+
 // TemplateEndpoint describes the configuration of an endpoint based on golang
-// templates.
+// templates.  If Stream is set the rendered template is piped through the post_render
+// steps to the client as it's produced rather than being held in memory, at the cost of
+// aborting the connection if an error occurs after the response has started.
 type TemplateEndpoint struct {
-	TemplatePath     string   `mapstructure:"template_path"`
-	RawContentType   string   `mapstructure:"raw_content_type"`
-	ContentType      string   `mapstructure:"content_type"`
-	DefaultTemplate  string   `mapstructure:"default_template"`
-	PostRender       []string `mapstructure:"post_render"`
-	RedirectInsecure bool     `mapstructure:"redirect_insecure"`
+	TemplatePath     string                   `mapstructure:"template_path"`
+	RawContentType   string                   `mapstructure:"raw_content_type"`
+	ContentType      string                   `mapstructure:"content_type"`
+	DefaultTemplate  string                   `mapstructure:"default_template"`
+	TemplateRules    []*TemplateRule          `mapstructure:"template_rules"`
+	Prefetch         map[string]*api.CallSpec `mapstructure:"prefetch"`
+	RequestHeaders   []string                 `mapstructure:"request_headers"`
+	PostRender       []interface{}            `mapstructure:"post_render"`
+	PostRenderOpts   PostRenderOptions        `mapstructure:"post_render_options"`
+	Stream           bool                     `mapstructure:"stream"`
+	RedirectInsecure bool                     `mapstructure:"redirect_insecure"`
+	RouteConfig      `mapstructure:",squash"`
+
+	// trustedProxies is set from the distro's trusted_proxies when it's loaded.
+	trustedProxies []*net.IPNet
+}
+
+// pipeExec returns a PipeExec running step in the distro folder with the endpoint's post_render options.
+func (e *TemplateEndpoint) pipeExec(basepath string, step *PostRenderStep) *pipe.PipeExec {
+	timeout := step.Timeout
+	if timeout == 0 {
+		timeout = e.PostRenderOpts.Timeout
+	}
+	if timeout == 0 {
+		timeout = DefaultPostRenderTimeout
+	}
+
+	contentType := step.ContentType
+	if contentType == "" {
+		contentType = e.ContentType
+	}
+
+	return &pipe.PipeExec{
+		Command:       append([]string{step.Command}, step.Args...),
+		ContentType:   contentType,
+		Dir:           basepath,
+		Env:           append(append([]string{}, e.PostRenderOpts.Env...), step.Env...),
+		Timeout:       timeout,
+		MaxOutputSize: e.PostRenderOpts.MaxOutputSize,
+		MaxMemory:     e.PostRenderOpts.MaxMemory,
+	}
+}
+
+// responsePipe returns the ResponsePipe for step.  Steps naming a transform are run in process, commands are
+// executed by a PipeExec.
+func (e *TemplateEndpoint) responsePipe(basepath string, step *PostRenderStep) (pipe.ResponsePipe, error) {
+	if step.Transform == "" {
+		return e.pipeExec(basepath, step), nil
+	}
+
+	p, err := pipe.NewNativePipe(step.Transform, basepath, step.Args)
+	if err != nil {
+		return nil, err
+	}
+	if step.ContentType != "" {
+		p.ContentType = step.ContentType
+	}
+	return p, nil
 }
 
 // CreateHandler returns a handler for the endpoint described by this configuration
@@ -219,15 +370,34 @@ func (e *TemplateEndpoint) CreateHandler(basepath string, _ string, dataSources
 	if e.RawContentType != "" {
 		headers["Content-type"] = e.RawContentType
 	}
-	tr := &TemplateRenderer{DefaultTemplate: e.DefaultTemplate, DataSources: dataSources}
-	h, err := templatehandler.NewTemplateHandler(filepath.Join(basepath, e.TemplatePath), headers, tr)
+	tr := &TemplateRenderer{DefaultTemplate: e.DefaultTemplate, DataSources: dataSources, Rules: e.TemplateRules, Prefetch: e.Prefetch, RequestHeaders: e.RequestHeaders, TrustedProxies: e.trustedProxies}
+	if _, err := tr.compiledPrefetch(); err != nil {
+		return nil, err
+	}
+
+	th, err := templatehandler.NewTemplateHandler(filepath.Join(basepath, e.TemplatePath), headers, tr)
 	if err != nil {
 		return nil, err
 	}
+	th.Stream = e.Stream
 
-	for _, post := range e.PostRender {
-		cmd := strings.Split(post, " ")
-		h = &pipe.PipeHandler{ResponsePipe: &pipe.PipeExec{Command: cmd, ContentType: e.ContentType}, Handler: h}
+	for _, rule := range e.TemplateRules {
+		if err := rule.validate(th.Template, dataSources); err != nil {
+			return nil, err
+		}
+	}
+	h = th
+
+	for i, post := range e.PostRender {
+		step, err := ParsePostRenderStep(post)
+		if err != nil {
+			return nil, fmt.Errorf("invalid post_render step %d: %v", i, err)
+		}
+		p, err := e.responsePipe(basepath, step)
+		if err != nil {
+			return nil, fmt.Errorf("invalid post_render step %d: %v", i, err)
+		}
+		h = &pipe.PipeHandler{ResponsePipe: p, Handler: h, Stream: e.Stream}
 	}
 
 	if e.RedirectInsecure {
@@ -12,7 +12,9 @@ import (
 	"text/template"
 
 	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+	templatehandler "github.com/PolarGeospatialCenter/pgcboot/pkg/handler/template"
 	"github.com/go-test/deep"
+	"github.com/gorilla/mux"
 	gock "gopkg.in/h2non/gock.v1"
 )
 
@@ -54,6 +56,137 @@ func TestTemplateData(t *testing.T) {
 	}
 }
 
+func TestTemplateRequestData(t *testing.T) {
+	cases := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		expected    interface{}
+	}{
+		{"json", http.MethodPost, "application/json", `{"mac": "00:11:22:33:44:55", "disks": 2}`, map[string]interface{}{"mac": "00:11:22:33:44:55", "disks": float64(2)}},
+		{"form", http.MethodPost, "application/x-www-form-urlencoded", "mac=00%3A11%3A22%3A33%3A44%3A55&disk=sda&disk=sdb", map[string]string{"mac": "00:11:22:33:44:55", "disk": "sda,sdb"}},
+		{"other", http.MethodPut, "text/plain", "hello", nil},
+		{"get", http.MethodGet, "", "", nil},
+	}
+
+	for _, c := range cases {
+		r, err := http.NewRequest(c.method, "http://localhost:8080/branch/master/ignition/00:11:22:33:44:55", strings.NewReader(c.body))
+		if err != nil {
+			t.Fatalf("Unable to create request: %v", err)
+		}
+		r.RemoteAddr = "10.0.0.2:54321"
+		r.Header.Set("Content-Type", c.contentType)
+		r.Header.Set("X-Forwarded-For", "10.1.0.30, 10.0.0.2")
+		r.Header.Set("User-Agent", "iPXE/1.0.0")
+		r.Header.Set("Authorization", "secret")
+		r = mux.SetURLVars(r, map[string]string{"mac": "00:11:22:33:44:55"})
+		r = DistroVars{}.SetContextForRequest(r)
+
+		trusted, err := parseTrustedProxies([]string{"10.0.0.0/24"})
+		if err != nil {
+			t.Fatalf("Unable to parse trusted proxies: %v", err)
+		}
+
+		renderer := &TemplateRenderer{RequestHeaders: []string{"user-agent", "X-Missing"}, TrustedProxies: trusted}
+		rawData, err := renderer.GetData(r)
+		if err != nil {
+			t.Errorf("%s: unable to get data from renderer: %v", c.name, err)
+			continue
+		}
+		data := rawData.(*TemplateData)
+
+		if data.Method != c.method {
+			t.Errorf("%s: got bad method: %s", c.name, data.Method)
+		}
+
+		if diff := deep.Equal(data.Headers, map[string]string{"User-Agent": "iPXE/1.0.0"}); diff != nil {
+			t.Errorf("%s: got bad headers: %v", c.name, diff)
+		}
+
+		if data.ClientIP != "10.1.0.30" {
+			t.Errorf("%s: got bad client ip: %s", c.name, data.ClientIP)
+		}
+
+		if data.PathVars["mac"] != "00:11:22:33:44:55" {
+			t.Errorf("%s: got bad path vars: %v", c.name, data.PathVars)
+		}
+
+		if diff := deep.Equal(data.Body, c.expected); diff != nil {
+			t.Errorf("%s: got bad body: %v", c.name, diff)
+		}
+	}
+}
+
+func TestTemplateRequestDataErrors(t *testing.T) {
+	r, err := http.NewRequest(http.MethodPost, "http://localhost:8080/foo", strings.NewReader(`{"mac": `))
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r = DistroVars{}.SetContextForRequest(r)
+
+	renderer := &TemplateRenderer{}
+	_, err = renderer.GetData(r)
+	if _, ok := err.(templatehandler.ErrBadRequest); !ok {
+		t.Errorf("expected a bad request error for an invalid json body, got %v", err)
+	}
+
+	if clientIP(&http.Request{RemoteAddr: "192.168.1.5:1234", Header: http.Header{}}, nil) != "192.168.1.5" {
+		t.Errorf("remote address not used when X-Forwarded-For isn't set")
+	}
+
+	r, err = http.NewRequest(http.MethodPost, "http://localhost:8080/foo", strings.NewReader(strings.Repeat(" ", maxRequestBodySize+1)))
+	if err != nil {
+		t.Fatalf("Unable to create request: %v", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r = DistroVars{}.SetContextForRequest(r)
+
+	_, err = renderer.GetData(r)
+	if _, ok := err.(templatehandler.ErrRequestTooLarge); !ok {
+		t.Errorf("expected a request too large error for an oversized body, got %v", err)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/24", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("Unable to parse trusted proxies: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"direct", "192.168.1.5:1234", nil, "192.168.1.5"},
+		{"spoofed", "192.168.1.5:1234", []string{"10.1.0.30"}, "192.168.1.5"},
+		{"trusted", "10.0.0.2:1234", []string{"10.1.0.30"}, "10.1.0.30"},
+		{"chained", "10.0.0.2:1234", []string{"10.1.0.30, 192.168.1.1"}, "10.1.0.30"},
+		{"prepended", "10.0.0.2:1234", []string{"1.2.3.4, 10.1.0.30"}, "10.1.0.30"},
+		{"multiple headers", "10.0.0.2:1234", []string{"1.2.3.4", "10.1.0.30"}, "10.1.0.30"},
+		{"invalid", "10.0.0.2:1234", []string{"bogus"}, "10.0.0.2"},
+		{"only proxies", "10.0.0.2:1234", []string{"10.0.0.3"}, "10.0.0.3"},
+	}
+
+	for _, c := range cases {
+		r := &http.Request{RemoteAddr: c.remoteAddr, Header: http.Header{"X-Forwarded-For": c.forwarded}}
+		if ip := clientIP(r, trusted); ip != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, ip)
+		}
+	}
+
+	if _, err := parseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("expected an error for an invalid trusted proxy range")
+	}
+
+	if _, err := parseTrustedProxies([]string{"proxy.local"}); err == nil {
+		t.Errorf("expected an error for an invalid trusted proxy address")
+	}
+}
+
 func TestTemplateAPICall(t *testing.T) {
 	gock.DisableNetworking()
 	defer gock.EnableNetworking()
@@ -122,7 +255,7 @@ func TestTemplatePostRenderContentType(t *testing.T) {
 	defer gock.Off() // Flush pending mocks after test execution
 
 	e := api.EndpointMap{}
-	ep := &TemplateEndpoint{TemplatePath: "foo", RawContentType: "text/yaml", ContentType: "application/json", DefaultTemplate: "default.tmpl.yml", PostRender: []string{"cat"}}
+	ep := &TemplateEndpoint{TemplatePath: "foo", RawContentType: "text/yaml", ContentType: "application/json", DefaultTemplate: "default.tmpl.yml", PostRender: []interface{}{"cat"}}
 	handler, err := ep.CreateHandler("../../test/data/branch/basic", "", e)
 	if err != nil {
 		t.Fatalf("unable to load endpoint for testing: %v", err)
@@ -350,3 +483,19 @@ func TestGetTemplateBaseURL(t *testing.T) {
 		t.Errorf("Wrong base url returned: %s", baseUrl)
 	}
 }
+
+func TestTemplateEndpointPipeExec(t *testing.T) {
+	e := &TemplateEndpoint{ContentType: "application/json", PostRenderOpts: PostRenderOptions{Env: []string{"CT_DEBUG"}, MaxOutputSize: 1024}}
+	p := e.pipeExec("/distro", &PostRenderStep{Command: "ct"})
+	if p.Dir != "/distro" {
+		t.Errorf("Command not run in the distro folder: %s", p.Dir)
+	}
+
+	if p.Timeout != DefaultPostRenderTimeout {
+		t.Errorf("Default timeout not applied: %s", p.Timeout)
+	}
+
+	if len(p.Env) != 1 || p.Env[0] != "CT_DEBUG" || p.MaxOutputSize != 1024 || p.ContentType != "application/json" {
+		t.Errorf("Post render options not applied: %+v", p)
+	}
+}
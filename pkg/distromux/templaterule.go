@@ -0,0 +1,107 @@
+package distromux
+
+import (
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+)
+
+// TemplateRule selects Template for requests where the value of exactly one of Param, Header,
+// DistroVar or Field (from a call to DataSource) equals Value.  If Value is empty any non-empty
+// value matches.
+type TemplateRule struct {
+	Template   string `mapstructure:"template"`
+	Param      string `mapstructure:"param"`
+	Header     string `mapstructure:"header"`
+	DistroVar  string `mapstructure:"var"`
+	DataSource string `mapstructure:"datasource"`
+	Field      string `mapstructure:"field"`
+	Value      string `mapstructure:"value"`
+}
+
+// validate checks that the rule is well formed and refers to a template and datasource that exist.
+func (rule *TemplateRule) validate(t *template.Template, dataSources api.EndpointMap) error {
+	if rule.Template == "" {
+		return fmt.Errorf("template rule has no template")
+	}
+
+	if t.Lookup(rule.Template) == nil {
+		return fmt.Errorf("template rule refers to missing template: %s", rule.Template)
+	}
+
+	sources := 0
+	for _, s := range []string{rule.Param, rule.Header, rule.DistroVar, rule.DataSource} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("template rule for %s must specify exactly one of param, header, var or datasource", rule.Template)
+	}
+
+	if rule.DataSource != "" {
+		if _, ok := dataSources[rule.DataSource]; !ok {
+			return fmt.Errorf("template rule for %s refers to missing datasource: %s", rule.Template, rule.DataSource)
+		}
+		if rule.Field == "" {
+			return fmt.Errorf("template rule for %s must specify a field to match against the datasource response", rule.Template)
+		}
+	}
+	return nil
+}
+
+// value returns the value of the request attribute this rule matches against. Datasource responses
+// are stored in responses so that each datasource is only called once per request.
+func (rule *TemplateRule) value(r *http.Request, dataSources api.EndpointMap, responses map[string]*api.APIResponse) (string, bool, error) {
+	switch {
+	case rule.Param != "":
+		values, ok := r.URL.Query()[rule.Param]
+		if !ok || len(values) == 0 {
+			return "", false, nil
+		}
+		return values[0], true, nil
+	case rule.Header != "":
+		if _, ok := r.Header[http.CanonicalHeaderKey(rule.Header)]; !ok {
+			return "", false, nil
+		}
+		return r.Header.Get(rule.Header), true, nil
+	case rule.DistroVar != "":
+		vars, _ := DistroVarsFromContext(r.Context())
+		v, ok := vars[rule.DistroVar]
+		if !ok {
+			return "", false, nil
+		}
+		return fmt.Sprintf("%v", v), true, nil
+	case rule.DataSource != "":
+		response, ok := responses[rule.DataSource]
+		if !ok {
+			var err error
+			response, err = dataSources.CallContext(r.Context(), rule.DataSource, "", r.URL.RawQuery, "")
+			if err != nil {
+				return "", false, fmt.Errorf("unable to call datasource %s for template selection: %w", rule.DataSource, err)
+			}
+			responses[rule.DataSource] = response
+		}
+		v, ok := lookupField(response.Data, rule.Field)
+		if !ok {
+			return "", false, nil
+		}
+		return fmt.Sprintf("%v", v), true, nil
+	}
+	return "", false, nil
+}
+
+// Matches returns true if the rule matches the request.
+func (rule *TemplateRule) Matches(r *http.Request, dataSources api.EndpointMap, responses map[string]*api.APIResponse) (bool, error) {
+	v, ok, err := rule.value(r, dataSources, responses)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if rule.Value == "" {
+		return v != "", nil
+	}
+	return v == rule.Value, nil
+}
@@ -0,0 +1,172 @@
+package distromux
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"text/template"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+	templatehandler "github.com/PolarGeospatialCenter/pgcboot/pkg/handler/template"
+	gock "gopkg.in/h2non/gock.v1"
+)
+
+func testRuleTemplates(t *testing.T) *template.Template {
+	tmpl := template.New("templatebase")
+	for _, name := range []string{"default.tmpl", "worker.tmpl", "storage.tmpl", "debug.tmpl", "dev.tmpl"} {
+		if _, err := tmpl.New(name).Parse(name); err != nil {
+			t.Fatalf("unable to parse test template: %v", err)
+		}
+	}
+	return tmpl
+}
+
+func TestTemplateSelector(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off()
+
+	dataSources := api.EndpointMap{"node": &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet}}
+	rules := []*TemplateRule{
+		&TemplateRule{Template: "debug.tmpl", Header: "X-Debug"},
+		&TemplateRule{Template: "dev.tmpl", DistroVar: "environment", Value: "dev"},
+		&TemplateRule{Template: "storage.tmpl", Param: "role", Value: "storage"},
+		&TemplateRule{Template: "worker.tmpl", DataSource: "node", Field: "Role", Value: "worker"},
+	}
+
+	cases := []struct {
+		name     string
+		url      string
+		header   string
+		vars     DistroVars
+		role     string
+		expected string
+	}{
+		{name: "header", url: "http://local/foo?id=pgc-0030", header: "X-Debug", role: "worker", expected: "debug.tmpl"},
+		{name: "distrovar", url: "http://local/foo?id=pgc-0030", vars: DistroVars{"environment": "dev"}, role: "worker", expected: "dev.tmpl"},
+		{name: "param", url: "http://local/foo?role=storage", expected: "storage.tmpl"},
+		{name: "datasource", url: "http://local/foo?id=pgc-0030", role: "worker", expected: "worker.tmpl"},
+		{name: "default", url: "http://local/foo?id=pgc-0030", role: "head", expected: "default.tmpl"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(st *testing.T) {
+			defer gock.Off()
+			gock.New("https://api.local/v1").
+				Get("/node").
+				Reply(200).
+				JSON(map[string]string{"Role": c.role})
+
+			r, err := http.NewRequest(http.MethodGet, c.url, nil)
+			if err != nil {
+				st.Fatalf("unable to create request: %v", err)
+			}
+			if c.header != "" {
+				r.Header.Set(c.header, "true")
+			}
+			r = c.vars.SetContextForRequest(r)
+
+			renderer := &TemplateRenderer{DefaultTemplate: "default.tmpl", DataSources: dataSources, Rules: rules}
+			selected, err := renderer.TemplateSelector(r, testRuleTemplates(st))
+			if err != nil {
+				st.Fatalf("unable to select template: %v", err)
+			}
+
+			if selected != c.expected {
+				st.Errorf("wrong template selected: expected %s, got %s", c.expected, selected)
+			}
+		})
+	}
+}
+
+func TestTemplateSelectorRuleData(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off() // Flush pending mocks after test execution
+
+	gock.New("https://api.local/v1").
+		Get("/node").
+		Reply(200).
+		JSON(map[string]string{"Role": "worker"})
+
+	r, err := http.NewRequest(http.MethodGet, "http://local/foo?id=pgc-0030", nil)
+	if err != nil {
+		t.Fatalf("unable to create request: %v", err)
+	}
+
+	dataSources := api.EndpointMap{"node": &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet}}
+	rules := []*TemplateRule{&TemplateRule{Template: "worker.tmpl", DataSource: "node", Field: "Role", Value: "worker"}}
+	renderer := &TemplateRenderer{DefaultTemplate: "default.tmpl", DataSources: dataSources, Rules: rules}
+
+	data := &TemplateData{}
+	selected, err := renderer.TemplateSelectorWithData(r, testRuleTemplates(t), data)
+	if err != nil || selected != "worker.tmpl" {
+		t.Fatalf("wrong template selected: %s %v", selected, err)
+	}
+
+	response, ok := data.RuleData["node"]
+	if !ok || response.Data.(map[string]interface{})["Role"] != "worker" {
+		t.Errorf("datasource response not added to the template data: %v", data.RuleData)
+	}
+}
+
+func TestTemplateSelectorSchemaError(t *testing.T) {
+	gock.DisableNetworking()
+	defer gock.EnableNetworking()
+	defer gock.Off() // Flush pending mocks after test execution
+
+	gock.New("https://api.local/v1").
+		Get("/node").
+		Reply(200).
+		JSON(map[string]string{"Role": "worker"})
+
+	r, err := http.NewRequest(http.MethodGet, "http://local/foo", nil)
+	if err != nil {
+		t.Fatalf("unable to create request: %v", err)
+	}
+
+	dataSources := api.EndpointMap{"node": &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet, Schema: "required: [IP]\n"}}
+	rules := []*TemplateRule{&TemplateRule{Template: "worker.tmpl", DataSource: "node", Field: "Role"}}
+	renderer := &TemplateRenderer{DefaultTemplate: "default.tmpl", DataSources: dataSources, Rules: rules}
+
+	_, err = renderer.TemplateSelector(r, testRuleTemplates(t))
+	var schemaErr *api.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Errorf("expected a schema error, got %v", err)
+	}
+}
+
+func TestTemplateSelectorNotFound(t *testing.T) {
+	r, err := http.NewRequest(http.MethodGet, "http://local/foo?role=head", nil)
+	if err != nil {
+		t.Fatalf("unable to create request: %v", err)
+	}
+
+	renderer := &TemplateRenderer{Rules: []*TemplateRule{&TemplateRule{Template: "storage.tmpl", Param: "role", Value: "storage"}}}
+	_, err = renderer.TemplateSelector(r, testRuleTemplates(t))
+	if _, ok := err.(templatehandler.ErrNotFound); !ok {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestTemplateRuleValidation(t *testing.T) {
+	dataSources := api.EndpointMap{"node": &api.Endpoint{URL: "https://api.local/v1/node", Method: http.MethodGet}}
+	cases := map[string]*TemplateRule{
+		"missing template":   &TemplateRule{Template: "missing.tmpl", Param: "role"},
+		"no source":          &TemplateRule{Template: "worker.tmpl"},
+		"multiple sources":   &TemplateRule{Template: "worker.tmpl", Param: "role", Header: "X-Role"},
+		"missing datasource": &TemplateRule{Template: "worker.tmpl", DataSource: "inventory", Field: "Role"},
+		"missing field":      &TemplateRule{Template: "worker.tmpl", DataSource: "node"},
+	}
+
+	for name, rule := range cases {
+		if err := rule.validate(testRuleTemplates(t), dataSources); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+
+	valid := &TemplateRule{Template: "worker.tmpl", DataSource: "node", Field: "Role"}
+	if err := valid.validate(testRuleTemplates(t), dataSources); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+}
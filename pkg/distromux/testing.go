@@ -42,9 +42,31 @@ func (m *MockDataSourceCall) mock(endpoints api.EndpointMap) (gock.Mock, error)
 	req := gock.NewRequest().SetURL(u).BodyString(m.Request.Body)
 	req.Method = m.Request.Method
 	res := gock.NewResponse().Status(m.Response.Status).BodyString(m.Response.Body)
+	for field, value := range m.Response.Headers {
+		res.SetHeader(field, value)
+	}
 	return gock.NewMock(req, res), nil
 }
 
+// validate checks a successful mocked response against the datasource's schema so that mocks can't drift from
+// the shape of the real service.
+func (m *MockDataSourceCall) validate(endpoints api.EndpointMap) error {
+	source, ok := endpoints[m.DataSource]
+	if !ok {
+		return fmt.Errorf("invalid datasource specified for mock: %s", m.DataSource)
+	}
+
+	if m.Response.Status < 200 || m.Response.Status >= 300 {
+		return nil
+	}
+
+	err := source.ValidateBody([]byte(m.Response.Body), m.Response.header("Content-Type"))
+	if schemaErr, ok := err.(*api.SchemaError); ok {
+		schemaErr.DataSource = m.DataSource
+	}
+	return err
+}
+
 type MockHTTPRequest struct {
 	Path    string                 `mapstructure:"path"`
 	Query   string                 `mapstructure:"query"`
@@ -80,9 +102,39 @@ func (r *MockHTTPRequest) BuildRequest(baseUrl *url.URL) (*http.Request, error)
 	return req, nil
 }
 
+// MockHTTPResponse describes a mocked datasource response or the response expected from a test.  Headers are
+// only used for mocked responses.
 type MockHTTPResponse struct {
-	Status int    `mapstructure:"status"`
-	Body   string `mapstructure:"body"`
+	Status  int               `mapstructure:"status"`
+	Body    string            `mapstructure:"body"`
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// header returns the value of the named header.  Header names are matched case insensitively since the config
+// loader lowercases map keys.
+func (r *MockHTTPResponse) header(name string) string {
+	for field, value := range r.Headers {
+		if strings.EqualFold(field, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// mockTransport answers requests using the registered gock mocks.  Unlike gock.Intercept it
+// doesn't replace http.DefaultTransport and never falls back to the network.
+type mockTransport struct{}
+
+func (mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	defer gock.Clean()
+	mock, err := gock.MatchMock(req)
+	if err != nil {
+		return nil, err
+	}
+	if mock == nil {
+		return nil, gock.ErrCannotMatch
+	}
+	return gock.Responder(req, mock.Response(), nil)
 }
 
 type DistroTestResult struct {
@@ -124,17 +176,22 @@ func (c *DistroTestCase) Test(mux *DistroMux, endpoints api.EndpointMap) *Distro
 	if err != nil {
 		return &DistroTestResult{Failed: true, Output: fmt.Sprintf("unable to create mock request: %v", err)}
 	}
-	// Mock api Endpoints
-	gock.DisableNetworking()
-	gock.Intercept()
-	defer gock.EnableNetworking()
+	// Mock api Endpoints.  The mock transport is only installed on the endpoints under test so
+	// that other users of http.DefaultTransport in the same process are unaffected.
 	defer gock.Off()
+	for _, e := range endpoints {
+		e.SetTransport(mockTransport{})
+		defer e.SetTransport(nil)
+	}
 
 	for _, mockedCall := range c.MockedData {
 		mock, err := mockedCall.mock(endpoints)
 		if err != nil {
 			return &DistroTestResult{Failed: true, Output: fmt.Sprintf("unable to create mock for data source call: %v", err)}
 		}
+		if err := mockedCall.validate(endpoints); err != nil {
+			return &DistroTestResult{Failed: true, Output: fmt.Sprintf("mocked response doesn't match the datasource schema: %v", err)}
+		}
 		gock.Register(mock)
 	}
 
@@ -224,3 +224,49 @@ func TestMockAPICallPOSTWithPath(t *testing.T) {
 		t.Errorf("Wrong result returned from mock.")
 	}
 }
+
+func TestMockSchemaValidation(t *testing.T) {
+	endpoints := api.EndpointMap{
+		"node": &api.Endpoint{URL: "http://local/v1/node", Method: "GET", Schema: `{"type": "object", "required": ["InventoryID"]}`},
+	}
+
+	valid := &MockDataSourceCall{DataSource: "node", Response: MockHTTPResponse{Status: 200, Body: `{"InventoryID": "pgc-0030"}`}}
+	if err := valid.validate(endpoints); err != nil {
+		t.Errorf("valid mocked response rejected: %v", err)
+	}
+
+	notFound := &MockDataSourceCall{DataSource: "node", Response: MockHTTPResponse{Status: 404, Body: `{}`}}
+	if err := notFound.validate(endpoints); err != nil {
+		t.Errorf("error responses shouldn't be validated: %v", err)
+	}
+
+	invalid := &MockDataSourceCall{DataSource: "node", Response: MockHTTPResponse{Status: 200, Body: `{"ID": "pgc-0030"}`}}
+	err := invalid.validate(endpoints)
+	if schemaErr, ok := err.(*api.SchemaError); !ok || schemaErr.DataSource != "node" || schemaErr.Field != "InventoryID" {
+		t.Errorf("expected a schema error for the invalid mocked response, got %v", err)
+	}
+}
+
+func TestMockSchemaValidationAutoFormat(t *testing.T) {
+	endpoints := api.EndpointMap{
+		"node": &api.Endpoint{URL: "http://local/v1/node", Method: "GET", Format: api.FormatAuto, Schema: `{"type": "object", "required": ["role"]}`},
+	}
+
+	// Header names are lowercased when test cases are loaded by viper
+	valid := &MockDataSourceCall{DataSource: "node", Response: MockHTTPResponse{
+		Status:  200,
+		Body:    "role: compute\n",
+		Headers: map[string]string{"content-type": "application/yaml"},
+	}}
+	if err := valid.validate(endpoints); err != nil {
+		t.Errorf("valid mocked response rejected: %v", err)
+	}
+
+	mock, err := valid.mock(endpoints)
+	if err != nil {
+		t.Fatalf("unable to build mock: %v", err)
+	}
+	if contentType := mock.Response().Header.Get("Content-Type"); contentType != "application/yaml" {
+		t.Errorf("wrong content type set on mocked response: %q", contentType)
+	}
+}
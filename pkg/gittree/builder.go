@@ -2,19 +2,38 @@ package treebuilder
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	billy "gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-billy.v4/osfs"
 	git "gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
 	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 )
 
+// CommitFile is the name of the file within each tree folder that records the hash of the commit
+// checked out into the folder.
+const CommitFile = ".gittree-commit"
+
+// ReadCommit returns the commit hash recorded in the tree folder at path.  An empty string is
+// returned if no commit has been recorded.
+func ReadCommit(path string) string {
+	commit, err := ioutil.ReadFile(filepath.Join(path, CommitFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(commit))
+}
+
 // GetPathFromRef returns the top-level subfolder name of the branch or tag
 func GetPathFromRef(ref *plumbing.Reference) string {
 	if ref.Type() == plumbing.HashReference && ref.Name().IsRemote() {
@@ -34,6 +53,7 @@ type Builder struct {
 	options *git.CloneOptions
 	store   storage.Storer
 	path    string
+	filter  *compiledRefFilter
 }
 
 // NewLocalBuilder creates a builder that points directly to a local bare repository.
@@ -47,32 +67,62 @@ func NewLocalBuilder(treePath, localRepoPath, bareRepoPath string) (*Builder, er
 	return b, nil
 }
 
-// NewSSHBuilder creates a builder object pointing to a remote git repo via ssh.
-// The repo is cloned (bare) to repo_path.  If repo_path doesn't exist it will be created.
+// NewBuilder creates a builder object pointing to a remote git repo using the supplied auth method.
+// A nil auth method may be used for unauthenticated remotes such as local paths and file:// urls.
+// The repo is cloned (bare) to repo_path by BuildGitTree.  If repo_path doesn't exist it will be created.
 // If repoPath already exists and contains a git repo, any updates will be fetched.
 //
 // The treePath is the root of the output file tree.
-func NewSSHBuilder(remote, deployKey, treePath, repoPath string) (*Builder, error) {
-	cloneOptions := &git.CloneOptions{URL: remote}
+func NewBuilder(remote string, auth transport.AuthMethod, treePath, repoPath string) (*Builder, error) {
+	cloneOptions := &git.CloneOptions{URL: remote, Auth: auth}
 	b := &Builder{options: cloneOptions, path: treePath}
-	err := b.SetSSHKey(deployKey)
-	if err != nil {
-		return nil, err
-	}
 
-	if _, err = os.Stat(repoPath); os.IsNotExist(err) {
+	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
 		os.MkdirAll(repoPath, 0755)
 	}
 
+	var err error
 	b.store, err = filesystem.NewStorage(osfs.New(repoPath))
 	if err != nil {
 		return nil, err
 	}
 
-	b.fetch()
 	return b, nil
 }
 
+// NewSSHBuilder creates a builder object pointing to a remote git repo via ssh using the supplied deploy key.
+// See NewBuilder for details.
+func NewSSHBuilder(remote, deployKey, treePath, repoPath string) (*Builder, error) {
+	b := &Builder{options: &git.CloneOptions{}}
+	err := b.SetSSHKey(deployKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewBuilder(remote, b.options.Auth, treePath, repoPath)
+}
+
+// NewHTTPSBuilder creates a builder object pointing to a remote git repo via http(s).  If a username or
+// password is supplied they are sent using basic auth.  Token based authentication is supported by most git
+// servers by supplying the token as the password.  See NewBuilder for details.
+func NewHTTPSBuilder(remote, username, password, treePath, repoPath string) (*Builder, error) {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse remote url: %v", err)
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported scheme for http remote: %s", remote)
+	}
+
+	var auth transport.AuthMethod
+	if username != "" || password != "" {
+		auth = &githttp.BasicAuth{Username: username, Password: password}
+	}
+
+	return NewBuilder(remote, auth, treePath, repoPath)
+}
+
 // SetSSHKey opens the supplied ssh key and configures the builder to use it for cloning and fetching.
 func (b *Builder) SetSSHKey(deployKeyPath string) error {
 	auth, err := ssh.NewPublicKeys("git", []byte(deployKeyPath), "")
@@ -107,14 +157,56 @@ func (b *Builder) fetch() error {
 	return err
 }
 
-// CloneRef creates a subfolder for a given ref and checks out the current contents.
+// resolveCommit returns the hash of the commit a ref points to, dereferencing annotated tags.
+func resolveCommit(repo *git.Repository, ref *plumbing.Reference) (plumbing.Hash, error) {
+	hash := ref.Hash()
+	if ref.Name().IsTag() {
+		tag, err := repo.TagObject(ref.Hash())
+		if err == nil {
+			commit, err := tag.Commit()
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			hash = commit.Hash
+		} else if err != plumbing.ErrObjectNotFound {
+			return plumbing.ZeroHash, err
+		}
+	}
+	return hash, nil
+}
+
+// CloneRef creates a subfolder for a given ref and checks out the current contents.  If the
+// folder already contains the commit the ref points to nothing is done, otherwise the commit is
+// checked out into a fresh directory that then replaces the existing folder.
 func (b *Builder) CloneRef(ref *plumbing.Reference) error {
 	workpath := filepath.Join(b.path, GetPathFromRef(ref))
-	if _, err := os.Stat(workpath); os.IsNotExist(err) {
-		os.MkdirAll(workpath, 0755)
+
+	repo, err := b.getRepository(nil)
+	if err != nil {
+		return err
+	}
+
+	hash, err := resolveCommit(repo, ref)
+	if err != nil {
+		return err
 	}
 
-	repo, err := b.getRepository(osfs.New(workpath))
+	if ReadCommit(workpath) == hash.String() {
+		log.Printf("%s is already at %s, skipping", workpath, hash)
+		return nil
+	}
+
+	if err := os.MkdirAll(b.path, 0755); err != nil {
+		return err
+	}
+
+	checkoutPath, err := ioutil.TempDir(b.path, ".checkout")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(checkoutPath)
+
+	repo, err = b.getRepository(osfs.New(checkoutPath))
 	if err != nil {
 		return err
 	}
@@ -124,29 +216,109 @@ func (b *Builder) CloneRef(ref *plumbing.Reference) error {
 	if err != nil {
 		return err
 	}
-	hash := ref.Hash()
-	if ref.Name().IsTag() {
-		tag, err := repo.TagObject(ref.Hash())
-		if err == nil {
-			commit, err := tag.Commit()
-			if err != nil {
-				return err
-			}
-			hash = commit.Hash
-		} else if err != plumbing.ErrObjectNotFound {
-			return err
-		}
-	}
 
 	log.Printf("Checking out: %s", hash)
 	err = tree.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
 	if err != nil {
 		return err
 	}
+
+	err = ioutil.WriteFile(filepath.Join(checkoutPath, CommitFile), []byte(hash.String()+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	return swapDir(checkoutPath, workpath)
+}
+
+// swapDir replaces the directory at dst with src.
+func swapDir(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		return os.Rename(src, dst)
+	}
+
+	old, err := ioutil.TempDir(filepath.Dir(dst), ".old")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(old)
+
+	oldPath := filepath.Join(old, filepath.Base(dst))
+	if err := os.Rename(dst, oldPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		// put the previous tree back in place
+		os.Rename(oldPath, dst)
+		return err
+	}
+	return nil
+}
+
+// Prune removes the folders from the tree that don't correspond to any of the supplied refs.  Folders
+// holding refs with a / in their name, such as branch/feature for branch/feature/foo, are walked down
+// to the folders of the refs, removing anything that isn't part of the path to a current ref.
+func (b *Builder) Prune(refs []*plumbing.Reference) error {
+	current := make(map[string]bool)
+	for _, ref := range refs {
+		current[filepath.Clean(GetPathFromRef(ref))] = true
+	}
+
+	for _, kind := range []string{"branch", "release"} {
+		if err := b.pruneFolder(kind, current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneFolder removes the entries of the folder at folderPath, relative to the tree, that aren't a current
+// ref and don't contain one.  Folders containing a current ref are pruned in turn.
+func (b *Builder) pruneFolder(folderPath string, current map[string]bool) error {
+	entries, err := ioutil.ReadDir(filepath.Join(b.path, folderPath))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entryPath := filepath.Join(folderPath, entry.Name())
+		switch {
+		case entry.IsDir() && current[entryPath]:
+			continue
+		case entry.IsDir() && containsSubPath(current, entryPath):
+			if err := b.pruneFolder(entryPath, current); err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Printf("Removing %s", entryPath)
+		if err := os.RemoveAll(filepath.Join(b.path, entryPath)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// FindRefs returns all tag/branch references for the repo
+// containsSubPath returns true if any of paths is nested below folder.  This is the case for
+// branches with a / in their name.
+func containsSubPath(paths map[string]bool, folder string) bool {
+	for p := range paths {
+		if strings.HasPrefix(p, folder+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindRefs returns all tag/branch references for the repo that match the builder's RefFilter, if any.
 func (b *Builder) FindRefs() ([]*plumbing.Reference, error) {
 	log.Println("Clone git repo")
 	r, err := b.getRepository(nil)
@@ -170,26 +342,45 @@ func (b *Builder) FindRefs() ([]*plumbing.Reference, error) {
 		return nil
 	})
 
+	if err == nil && b.filter != nil {
+		references = b.filter.Filter(references)
+	}
 	return references, err
 }
 
-// BuildGitTree checks out each branch or tag of the repo into the tree_path
+// BuildGitTree checks out each branch or tag of the repo into the tree_path.  Folders already at the
+// current commit for their ref are left untouched and folders for refs that no longer exist are removed.
+// A ref that can't be checked out keeps its previous folder, if any, and doesn't stop the others from
+// being updated; the errors for all such refs are returned together once the tree has been pruned.
 func (b *Builder) BuildGitTree() error {
+	log.Println("Fetch updates from remote")
+	if err := b.fetch(); err != nil {
+		return fmt.Errorf("unable to fetch from %s: %v", b.options.URL, err)
+	}
+
 	log.Println("Find all relevant references")
 	refs, err := b.FindRefs()
 	if err != nil {
 		return err
 	}
+
+	log.Println("Update trees")
+	errs := make([]string, 0)
 	for _, ref := range refs {
-		log.Println(ref.Name().Short())
-		log.Println(GetPathFromRef(ref))
-		err = b.CloneRef(ref)
-		if err != nil {
-			return err
+		log.Printf("%s -> %s", ref.Name().Short(), GetPathFromRef(ref))
+		if err := b.CloneRef(ref); err != nil {
+			log.Printf("Unable to check out %s: %v", ref.Name().Short(), err)
+			errs = append(errs, fmt.Sprintf("%s: %v", ref.Name().Short(), err))
 		}
 	}
-	log.Println("Remove now irellevant trees")
-	log.Println("Checkout new trees")
-	log.Println("Update existing trees")
+
+	log.Println("Remove now irrelevant trees")
+	if err := b.Prune(refs); err != nil {
+		errs = append(errs, fmt.Sprintf("prune: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to update tree: %s", strings.Join(errs, "; "))
+	}
 	return nil
 }
@@ -5,9 +5,12 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
 func TestSSHLoad(t *testing.T) {
@@ -82,4 +85,208 @@ func TestLocalBuilder(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Unable to build git tree.")
 	}
+
+	refs, err := b.FindRefs()
+	if err != nil {
+		t.Fatalf("Unable to find refs: %v", err)
+	}
+
+	for _, ref := range refs {
+		if ReadCommit(path.Join(workDir, GetPathFromRef(ref))) == "" {
+			t.Errorf("No commit recorded for %s", ref.Name())
+		}
+	}
+}
+
+func TestIncrementalBuild(t *testing.T) {
+	repoDir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoDir)
+
+	workDir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	cwd, _ := os.Getwd()
+	b, err := NewLocalBuilder(workDir, repoDir, path.Clean(path.Join(cwd, "..", "..", "test", "repo")))
+	if err != nil {
+		t.Fatalf("Error creating local builder: %v", err)
+	}
+
+	err = b.BuildGitTree()
+	if err != nil {
+		t.Fatalf("Unable to build git tree: %v", err)
+	}
+
+	// unchanged trees should not be checked out again
+	marker := path.Join(workDir, "branch", "master", "marker")
+	if err := ioutil.WriteFile(marker, []byte{}, 0644); err != nil {
+		t.Fatalf("Unable to write marker file: %v", err)
+	}
+
+	// changed trees should be replaced
+	changed := path.Join(workDir, "release", "v0.0.1")
+	if err := ioutil.WriteFile(path.Join(changed, CommitFile), []byte("0000"), 0644); err != nil {
+		t.Fatalf("Unable to overwrite commit file: %v", err)
+	}
+	if err := ioutil.WriteFile(path.Join(changed, "marker"), []byte{}, 0644); err != nil {
+		t.Fatalf("Unable to write marker file: %v", err)
+	}
+
+	// trees for refs that no longer exist should be removed
+	stale := path.Join(workDir, "branch", "deleted")
+	if err := os.MkdirAll(stale, 0755); err != nil {
+		t.Fatalf("Unable to create stale folder: %v", err)
+	}
+
+	err = b.BuildGitTree()
+	if err != nil {
+		t.Fatalf("Unable to rebuild git tree: %v", err)
+	}
+
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Unchanged tree was replaced: %v", err)
+	}
+
+	if _, err := os.Stat(path.Join(changed, "marker")); !os.IsNotExist(err) {
+		t.Errorf("Changed tree wasn't replaced")
+	}
+
+	if ReadCommit(changed) != "92f6c369c307a55e79d0a6833ce3be8377969c59" {
+		t.Errorf("Wrong commit recorded for changed tree: %s", ReadCommit(changed))
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Stale tree wasn't removed")
+	}
+
+	leftovers, _ := filepath.Glob(path.Join(workDir, ".*"))
+	if len(leftovers) > 0 {
+		t.Errorf("Temporary checkout folders left behind: %v", leftovers)
+	}
+}
+
+func TestBuildContinuesAfterRefError(t *testing.T) {
+	repoDir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoDir)
+
+	workDir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	cwd, _ := os.Getwd()
+	b, err := NewLocalBuilder(workDir, repoDir, path.Clean(path.Join(cwd, "..", "..", "test", "repo")))
+	if err != nil {
+		t.Fatalf("Error creating local builder: %v", err)
+	}
+
+	// a file in place of the release folder stops the tag from being checked out
+	if err := ioutil.WriteFile(path.Join(workDir, "release"), []byte{}, 0644); err != nil {
+		t.Fatalf("Unable to write blocking file: %v", err)
+	}
+
+	stale := path.Join(workDir, "branch", "deleted")
+	if err := os.MkdirAll(stale, 0755); err != nil {
+		t.Fatalf("Unable to create stale folder: %v", err)
+	}
+
+	err = b.BuildGitTree()
+	if err == nil || !strings.Contains(err.Error(), "v0.0.1") {
+		t.Errorf("Expected an error for the release, got: %v", err)
+	}
+
+	if ReadCommit(path.Join(workDir, "branch", "master")) == "" || ReadCommit(path.Join(workDir, "branch", "testbranch")) == "" {
+		t.Errorf("Branches weren't checked out after the release failed")
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Stale tree wasn't removed")
+	}
+}
+
+func TestUnauthenticatedBuilder(t *testing.T) {
+	repoDir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoDir)
+
+	workDir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	cwd, _ := os.Getwd()
+	b, err := NewBuilder(path.Clean(path.Join(cwd, "..", "..", "test", "repo")), nil, workDir, path.Join(repoDir, "repo"))
+	if err != nil {
+		t.Fatalf("Error creating builder: %v", err)
+	}
+
+	err = b.BuildGitTree()
+	if err != nil {
+		t.Fatalf("Unable to build git tree: %v", err)
+	}
+
+	if ReadCommit(path.Join(workDir, "branch", "master")) != "062d895d9f3443ebc6888a1f7a0faf17884b67fc" {
+		t.Errorf("Master branch not checked out")
+	}
+}
+
+func TestHTTPSBuilderScheme(t *testing.T) {
+	_, err := NewHTTPSBuilder("git@github.com:PolarGeospatialCenter/pgcboot.git", "git", "token", "", "")
+	if err == nil {
+		t.Errorf("Expected error creating https builder for ssh remote")
+	}
+}
+
+func TestPruneNestedBranches(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	folders := []string{"branch/master", "branch/feature/foo", "branch/feature/bar", "branch/feature/old/baz", "release/v0.0.1", "release/v0.0.2"}
+	for _, folder := range folders {
+		if err := os.MkdirAll(path.Join(workDir, folder, "data"), 0755); err != nil {
+			t.Fatalf("Unable to create folder: %v", err)
+		}
+	}
+	// left behind by a branch that has been replaced by branches nested below it
+	if err := ioutil.WriteFile(path.Join(workDir, "branch", "feature", CommitFile), []byte("0000"), 0644); err != nil {
+		t.Fatalf("Unable to write commit file: %v", err)
+	}
+
+	refs := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/remotes/origin/master", plumbing.ZeroHash),
+		plumbing.NewHashReference("refs/remotes/origin/feature/foo", plumbing.ZeroHash),
+		plumbing.NewHashReference("refs/tags/v0.0.1", plumbing.ZeroHash),
+	}
+
+	b := &Builder{path: workDir}
+	if err := b.Prune(refs); err != nil {
+		t.Fatalf("Unable to prune tree: %v", err)
+	}
+
+	for _, folder := range []string{"branch/master", "branch/master/data", "branch/feature/foo/data", "release/v0.0.1"} {
+		if _, err := os.Stat(path.Join(workDir, folder)); err != nil {
+			t.Errorf("Current folder %s was removed: %v", folder, err)
+		}
+	}
+
+	for _, folder := range []string{"branch/feature/bar", "branch/feature/old", "branch/feature/" + CommitFile, "release/v0.0.2"} {
+		if _, err := os.Stat(path.Join(workDir, folder)); !os.IsNotExist(err) {
+			t.Errorf("Stale entry %s wasn't removed", folder)
+		}
+	}
 }
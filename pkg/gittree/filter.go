@@ -0,0 +1,169 @@
+package treebuilder
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// regexPrefix marks a filter pattern as a regular expression rather than a glob.
+const regexPrefix = "regex:"
+
+// RefFilter selects which branches and tags are checked out.  Patterns are globs as understood by
+// path.Match unless prefixed with "regex:", in which case the remainder is a regular expression.
+// An empty include list matches everything.  Exclude patterns are applied after include patterns.
+//
+// If MaxReleases is greater than zero only the newest MaxReleases tags, ordered by semantic version,
+// are kept.  Tags that aren't valid semantic versions are dropped in that case.
+type RefFilter struct {
+	IncludeBranches []string
+	ExcludeBranches []string
+	IncludeTags     []string
+	ExcludeTags     []string
+	MaxReleases     int
+}
+
+type refMatcher func(string) bool
+
+func compilePattern(pattern string) (refMatcher, error) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, regexPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ref pattern %s: %v", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid ref pattern %s: %v", pattern, err)
+	}
+	return func(name string) bool {
+		match, _ := path.Match(pattern, name)
+		return match
+	}, nil
+}
+
+func compilePatterns(patterns []string) ([]refMatcher, error) {
+	matchers := make([]refMatcher, 0, len(patterns))
+	for _, p := range patterns {
+		m, err := compilePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func matchAny(matchers []refMatcher, name string) bool {
+	for _, m := range matchers {
+		if m(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// compiledRefFilter is a RefFilter with its patterns compiled.
+type compiledRefFilter struct {
+	includeBranches []refMatcher
+	excludeBranches []refMatcher
+	includeTags     []refMatcher
+	excludeTags     []refMatcher
+	maxReleases     int
+}
+
+func (f *RefFilter) compile() (*compiledRefFilter, error) {
+	var err error
+	c := &compiledRefFilter{maxReleases: f.MaxReleases}
+	if c.includeBranches, err = compilePatterns(f.IncludeBranches); err != nil {
+		return nil, err
+	}
+	if c.excludeBranches, err = compilePatterns(f.ExcludeBranches); err != nil {
+		return nil, err
+	}
+	if c.includeTags, err = compilePatterns(f.IncludeTags); err != nil {
+		return nil, err
+	}
+	if c.excludeTags, err = compilePatterns(f.ExcludeTags); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func included(include, exclude []refMatcher, name string) bool {
+	if len(include) > 0 && !matchAny(include, name) {
+		return false
+	}
+	return !matchAny(exclude, name)
+}
+
+// branchName returns the name of a remote branch without the remote name.
+func branchName(ref *plumbing.Reference) string {
+	parts := strings.SplitN(ref.Name().Short(), "/", 2)
+	if len(parts) != 2 {
+		return ref.Name().Short()
+	}
+	return parts[1]
+}
+
+// Filter returns the refs that match the filter.
+func (c *compiledRefFilter) Filter(refs []*plumbing.Reference) []*plumbing.Reference {
+	result := make([]*plumbing.Reference, 0, len(refs))
+	type release struct {
+		ref     *plumbing.Reference
+		version *semver.Version
+	}
+	releases := make([]release, 0)
+
+	for _, ref := range refs {
+		switch {
+		case ref.Name().IsRemote():
+			if included(c.includeBranches, c.excludeBranches, branchName(ref)) {
+				result = append(result, ref)
+			}
+		case ref.Name().IsTag():
+			tag := ref.Name().Short()
+			if !included(c.includeTags, c.excludeTags, tag) {
+				continue
+			}
+
+			if c.maxReleases <= 0 {
+				result = append(result, ref)
+				continue
+			}
+
+			v, err := semver.NewVersion(tag)
+			if err != nil {
+				continue
+			}
+			releases = append(releases, release{ref: ref, version: v})
+		}
+	}
+
+	sort.Slice(releases, func(i, j int) bool { return releases[i].version.GreaterThan(releases[j].version) })
+	for i := 0; i < len(releases) && i < c.maxReleases; i++ {
+		result = append(result, releases[i].ref)
+	}
+	return result
+}
+
+// SetRefFilter configures the builder to only check out the branches and tags selected by f.
+func (b *Builder) SetRefFilter(f *RefFilter) error {
+	if f == nil {
+		b.filter = nil
+		return nil
+	}
+
+	c, err := f.compile()
+	if err != nil {
+		return err
+	}
+	b.filter = c
+	return nil
+}
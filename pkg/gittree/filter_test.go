@@ -0,0 +1,97 @@
+package treebuilder
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/go-test/deep"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func testRefs(names ...string) []*plumbing.Reference {
+	refs := make([]*plumbing.Reference, 0, len(names))
+	for _, n := range names {
+		refs = append(refs, plumbing.NewHashReference(plumbing.ReferenceName(n), plumbing.ZeroHash))
+	}
+	return refs
+}
+
+func refPaths(refs []*plumbing.Reference) []string {
+	paths := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		paths = append(paths, GetPathFromRef(ref))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestRefFilter(t *testing.T) {
+	refs := testRefs(
+		"refs/remotes/origin/master",
+		"refs/remotes/origin/develop",
+		"refs/remotes/origin/jdoe-experiment",
+		"refs/remotes/origin/release-1.2",
+		"refs/tags/v1.0.0",
+		"refs/tags/v1.10.0",
+		"refs/tags/v1.2.0",
+		"refs/tags/v1.3.0-rc1",
+		"refs/tags/old-tag",
+	)
+
+	cases := []struct {
+		name     string
+		filter   *RefFilter
+		expected []string
+	}{
+		{
+			name:     "no filter",
+			filter:   &RefFilter{},
+			expected: refPaths(refs),
+		},
+		{
+			name:   "include and exclude globs",
+			filter: &RefFilter{IncludeBranches: []string{"master", "develop", "release-*"}, ExcludeTags: []string{"*-rc*", "old-*"}},
+			expected: []string{
+				"branch/develop", "branch/master", "branch/release-1.2",
+				"release/v1.0.0", "release/v1.10.0", "release/v1.2.0",
+			},
+		},
+		{
+			name:     "regex",
+			filter:   &RefFilter{ExcludeBranches: []string{"regex:^[a-z]+-"}, IncludeTags: []string{`regex:^v1\.[0-9]+\.0$`}},
+			expected: []string{"branch/develop", "branch/master", "release/v1.0.0", "release/v1.10.0", "release/v1.2.0"},
+		},
+		{
+			name:     "newest releases",
+			filter:   &RefFilter{IncludeBranches: []string{"master"}, MaxReleases: 2},
+			expected: []string{"branch/master", "release/v1.10.0", "release/v1.3.0-rc1"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(st *testing.T) {
+			compiled, err := c.filter.compile()
+			if err != nil {
+				st.Fatalf("unable to compile filter: %v", err)
+			}
+
+			if diff := deep.Equal(refPaths(compiled.Filter(refs)), c.expected); len(diff) > 0 {
+				st.Error("Filtered refs don't match expected:")
+				for _, l := range diff {
+					st.Error(l)
+				}
+			}
+		})
+	}
+}
+
+func TestRefFilterInvalidPattern(t *testing.T) {
+	b := &Builder{}
+	if err := b.SetRefFilter(&RefFilter{IncludeBranches: []string{"regex:("}}); err == nil {
+		t.Errorf("Expected error for invalid regex")
+	}
+
+	if err := b.SetRefFilter(&RefFilter{IncludeTags: []string{"["}}); err == nil {
+		t.Errorf("Expected error for invalid glob")
+	}
+}
@@ -0,0 +1,78 @@
+package treebuilder
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	gitssh "gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyError is returned when the remote host key can't be verified.
+type HostKeyError struct {
+	Host        string
+	Fingerprint string
+	Reason      string
+}
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("ssh host key verification failed for %s (%s): %s", e.Host, e.Fingerprint, e.Reason)
+}
+
+// NewHostKeyCallback returns an ssh.HostKeyCallback that verifies the remote host key against the supplied
+// known_hosts files and pinned fingerprints.  Fingerprints may be in either the SHA256:... or legacy MD5
+// format printed by ssh-keygen -l.  If both are supplied the key must pass both checks.  At least one known_hosts
+// file or fingerprint must be supplied.
+func NewHostKeyCallback(knownHostsFiles []string, fingerprints []string) (ssh.HostKeyCallback, error) {
+	if len(knownHostsFiles) == 0 && len(fingerprints) == 0 {
+		return nil, fmt.Errorf("no known_hosts files or host key fingerprints supplied")
+	}
+
+	var knownHostsCallback ssh.HostKeyCallback
+	if len(knownHostsFiles) > 0 {
+		var err error
+		knownHostsCallback, err = knownhosts.New(knownHostsFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load known_hosts: %v", err)
+		}
+	}
+
+	pinned := make(map[string]bool)
+	for _, f := range fingerprints {
+		pinned[strings.TrimSpace(f)] = true
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(pinned) > 0 && !pinned[fingerprint] && !pinned[ssh.FingerprintLegacyMD5(key)] {
+			return &HostKeyError{Host: hostname, Fingerprint: fingerprint, Reason: "host key doesn't match any pinned fingerprint"}
+		}
+
+		if knownHostsCallback != nil {
+			if err := knownHostsCallback(hostname, remote, key); err != nil {
+				return &HostKeyError{Host: hostname, Fingerprint: fingerprint, Reason: err.Error()}
+			}
+		}
+		return nil
+	}, nil
+}
+
+// SetSSHHostKeys configures the builder to verify the remote host key against the supplied known_hosts files
+// and pinned fingerprints.  SetSSHKey must be called first.
+func (b *Builder) SetSSHHostKeys(knownHostsFiles []string, fingerprints []string) error {
+	auth, ok := b.options.Auth.(*gitssh.PublicKeys)
+	if !ok {
+		return fmt.Errorf("host key verification is only supported for ssh remotes")
+	}
+
+	callback, err := NewHostKeyCallback(knownHostsFiles, fingerprints)
+	if err != nil {
+		return err
+	}
+
+	auth.HostKeyCallback = callback
+	return nil
+}
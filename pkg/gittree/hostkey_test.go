@@ -0,0 +1,86 @@
+package treebuilder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func testHostKey(t *testing.T) ssh.PublicKey {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatalf("Unable to create ssh public key: %v", err)
+	}
+	return key
+}
+
+func TestHostKeyFingerprint(t *testing.T) {
+	key := testHostKey(t)
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	callback, err := NewHostKeyCallback(nil, []string{ssh.FingerprintSHA256(key)})
+	if err != nil {
+		t.Fatalf("Unable to create host key callback: %v", err)
+	}
+
+	if err := callback("github.com:22", addr, key); err != nil {
+		t.Errorf("Pinned host key rejected: %v", err)
+	}
+
+	err = callback("github.com:22", addr, testHostKey(t))
+	if _, ok := err.(*HostKeyError); !ok {
+		t.Errorf("Expected HostKeyError for mismatched key, got: %v", err)
+	}
+}
+
+func TestHostKeyKnownHosts(t *testing.T) {
+	key := testHostKey(t)
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	dir, err := ioutil.TempDir("", "knownhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	knownHostsFile := path.Join(dir, "known_hosts")
+	err = ioutil.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{"github.com"}, key)+"\n"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write known_hosts: %v", err)
+	}
+
+	callback, err := NewHostKeyCallback([]string{knownHostsFile}, nil)
+	if err != nil {
+		t.Fatalf("Unable to create host key callback: %v", err)
+	}
+
+	if err := callback("github.com:22", addr, key); err != nil {
+		t.Errorf("Known host key rejected: %v", err)
+	}
+
+	if err := callback("github.com:22", addr, testHostKey(t)); err == nil {
+		t.Errorf("Expected error for mismatched key")
+	}
+
+	if err := callback("unknown.example.com:22", addr, key); err == nil {
+		t.Errorf("Expected error for unknown host")
+	}
+}
+
+func TestHostKeyCallbackRequiresConfig(t *testing.T) {
+	if _, err := NewHostKeyCallback(nil, nil); err == nil {
+		t.Errorf("Expected error creating host key callback without known_hosts or fingerprints")
+	}
+}
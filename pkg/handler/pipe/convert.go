@@ -0,0 +1,123 @@
+package pipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/PolarGeospatialCenter/pgcboot/pkg/api"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func init() {
+	RegisterNative("yaml2json", "application/json", newYAMLToJSON)
+	RegisterNative("json2yaml", "application/x-yaml", newJSONToYAML)
+	RegisterNative("jsonmerge", "application/json", newJSONMerge)
+}
+
+// decodeYAML reads a yaml, or json, document from r.
+func decodeYAML(r io.Reader) (interface{}, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return api.NormalizeYAML(value), nil
+}
+
+// newYAMLToJSON converts a yaml document to json.
+func newYAMLToJSON(_ string, args []string) (TransformFunc, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	return func(w io.Writer, r io.Reader) error {
+		value, err := decodeYAML(r)
+		if err != nil {
+			return fmt.Errorf("unable to parse yaml: %v", err)
+		}
+		return json.NewEncoder(w).Encode(value)
+	}, nil
+}
+
+// newJSONToYAML converts a json document to yaml.
+func newJSONToYAML(_ string, args []string) (TransformFunc, error) {
+	if err := noArgs(args); err != nil {
+		return nil, err
+	}
+
+	return func(w io.Writer, r io.Reader) error {
+		var value interface{}
+		if err := json.NewDecoder(r).Decode(&value); err != nil {
+			return fmt.Errorf("unable to parse json: %v", err)
+		}
+
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}, nil
+}
+
+// mergeValues deep merges override into base.  Objects are merged key by key, any other value in override
+// replaces the value in base.
+func mergeValues(base, override interface{}) interface{} {
+	baseMap, baseOk := base.(map[string]interface{})
+	overrideMap, overrideOk := override.(map[string]interface{})
+	if !baseOk || !overrideOk {
+		return override
+	}
+
+	merged := make(map[string]interface{}, len(baseMap)+len(overrideMap))
+	for key, value := range baseMap {
+		merged[key] = value
+	}
+	for key, value := range overrideMap {
+		if existing, ok := merged[key]; ok {
+			value = mergeValues(existing, value)
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// newJSONMerge merges the json, or yaml, response into the file given as the only argument.  Values from the
+// response take precedence over those in the file.  The file must be inside dir.
+func newJSONMerge(dir string, args []string) (TransformFunc, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected the path of the file to merge with")
+	}
+
+	clean := filepath.Clean(args[0])
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("merge file must be a relative path inside the distro folder: %s", args[0])
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, clean))
+	if err != nil {
+		return nil, err
+	}
+
+	var base interface{}
+	if err := yaml.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", args[0], err)
+	}
+	base = api.NormalizeYAML(base)
+
+	return func(w io.Writer, r io.Reader) error {
+		value, err := decodeYAML(r)
+		if err != nil {
+			return fmt.Errorf("unable to parse response: %v", err)
+		}
+		return json.NewEncoder(w).Encode(mergeValues(base, value))
+	}, nil
+}
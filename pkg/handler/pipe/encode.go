@@ -0,0 +1,76 @@
+package pipe
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterNative("gzip", "application/gzip", newGzip)
+	RegisterNative("base64", "text/plain", newBase64)
+}
+
+// newGzip compresses the response.  The compression level may be given as -1 to -9, -c is accepted and
+// ignored for compatibility with the gzip command.
+func newGzip(_ string, args []string) (TransformFunc, error) {
+	level := gzip.DefaultCompression
+	for _, arg := range args {
+		if arg == "-c" {
+			continue
+		}
+
+		l, err := strconv.Atoi(strings.TrimPrefix(arg, "-"))
+		if !strings.HasPrefix(arg, "-") || err != nil || l < gzip.BestSpeed || l > gzip.BestCompression {
+			return nil, fmt.Errorf("unsupported argument: %s", arg)
+		}
+		level = l
+	}
+
+	return func(w io.Writer, r io.Reader) error {
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(gz, r); err != nil {
+			gz.Close()
+			return err
+		}
+		return gz.Close()
+	}, nil
+}
+
+// newBase64 encodes the response using standard base64 encoding, or decodes it if -d is given.  Output is
+// never wrapped, so -w0 is accepted and ignored.
+func newBase64(_ string, args []string) (TransformFunc, error) {
+	var decode bool
+	for _, arg := range args {
+		switch arg {
+		case "-d", "--decode":
+			decode = true
+		case "-w0":
+		default:
+			return nil, fmt.Errorf("unsupported argument: %s", arg)
+		}
+	}
+
+	if decode {
+		return func(w io.Writer, r io.Reader) error {
+			_, err := io.Copy(w, base64.NewDecoder(base64.StdEncoding, r))
+			return err
+		}, nil
+	}
+
+	return func(w io.Writer, r io.Reader) error {
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		if _, err := io.Copy(enc, r); err != nil {
+			enc.Close()
+			return err
+		}
+		return enc.Close()
+	}, nil
+}
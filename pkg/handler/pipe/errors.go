@@ -0,0 +1,79 @@
+package pipe
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	templatehandler "github.com/PolarGeospatialCenter/pgcboot/pkg/handler/template"
+)
+
+// transformFailedMessage is reported to clients when a response couldn't be transformed.
+const transformFailedMessage = "Unable to transform response."
+
+// ExecError describes a command that failed to transform a response.  ExitCode is -1 if the command didn't
+// exit normally, Stderr holds the start of the command's error output.
+type ExecError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *ExecError) Error() string {
+	msg := fmt.Sprintf("error running command '%s': %v", e.Command, e.Err)
+	if e.Stderr != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Stderr)
+	}
+	return msg
+}
+
+// ResponseStatus returns http.StatusInternalServerError.
+func (e *ExecError) ResponseStatus() int {
+	return http.StatusInternalServerError
+}
+
+// ResponseBody describes the failed command.
+func (e *ExecError) ResponseBody() interface{} {
+	return map[string]interface{}{
+		"msg":       transformFailedMessage,
+		"command":   e.Command,
+		"exit_code": e.ExitCode,
+		"error":     e.Err.Error(),
+		"stderr":    e.Stderr,
+	}
+}
+
+// NativeError describes a native transform that failed.
+type NativeError struct {
+	Transform string
+	Err       error
+}
+
+func (e *NativeError) Error() string {
+	return fmt.Sprintf("error running transform %s: %v", e.Transform, e.Err)
+}
+
+// ResponseStatus returns http.StatusInternalServerError.
+func (e *NativeError) ResponseStatus() int {
+	return http.StatusInternalServerError
+}
+
+// ResponseBody describes the failed transform.
+func (e *NativeError) ResponseBody() interface{} {
+	return map[string]interface{}{
+		"msg":       transformFailedMessage,
+		"transform": e.Transform,
+		"error":     e.Err.Error(),
+	}
+}
+
+// renderTransformError reports err to the client as JSON.
+func renderTransformError(w http.ResponseWriter, err error) {
+	log.Printf("An error ocurred while transforming response: %v", err)
+	if responseErr, ok := err.(templatehandler.ResponseError); ok {
+		templatehandler.RenderJson(w, responseErr.ResponseStatus(), responseErr.ResponseBody())
+		return
+	}
+	templatehandler.RenderJsonError(w, http.StatusInternalServerError, fmt.Errorf(transformFailedMessage))
+}
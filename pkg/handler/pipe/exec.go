@@ -7,52 +7,125 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/honeycombio/beeline-go/trace"
 )
 
+// DefaultEnv lists the environment variables passed to every command in addition to those in PipeExec.Env.
+var DefaultEnv = []string{"PATH", "HOME", "LANG", "TZ"}
+
+// maxStderrSize is the amount of stderr output kept for error messages.
+const maxStderrSize = 4096
+
 // PipeExec reads from stdin passing the data into stdin of the command.
 // stdout is written to the provided writers.
 // The content type is set as directed
+//
+// Commands don't inherit the server's environment.  Only the variables named in DefaultEnv and Env are passed
+// through, entries of Env in the form NAME=value are set explicitly.  The command is killed if it runs for
+// longer than Timeout, the request is canceled, or it writes more than MaxOutputSize bytes to stdout.
+// MaxMemory limits the virtual memory available to the command, in bytes.
 type PipeExec struct {
-	Command     []string
-	ContentType string
+	Command       []string
+	ContentType   string
+	Dir           string
+	Env           []string
+	Timeout       time.Duration
+	MaxOutputSize int64
+	MaxMemory     int64
 }
 
 func (p *PipeExec) Transform(ctx context.Context, r *http.Response) error {
 	var out bytes.Buffer
 
-	err := p.run(ctx, &out, r.Body)
+	err := p.Stream(ctx, &out, r.Body, r.Header)
 	if err != nil {
-		return fmt.Errorf("error running command '%s': %v", strings.Join(p.Command, " "), err)
+		return err
 	}
 	r.Body = ioutil.NopCloser(&out)
-	r.Header.Set("Content-type", p.ContentType)
 	return nil
 }
 
+// Stream pipes r through the command to w.
+func (p *PipeExec) Stream(ctx context.Context, w io.Writer, r io.Reader, header http.Header) error {
+	header.Set("Content-type", p.ContentType)
+	return p.run(ctx, w, r)
+}
+
+// environ returns the environment for the command.
+func (p *PipeExec) environ() []string {
+	env := make([]string, 0, len(DefaultEnv)+len(p.Env))
+	for _, name := range append(append([]string{}, DefaultEnv...), p.Env...) {
+		if strings.Contains(name, "=") {
+			env = append(env, name)
+			continue
+		}
+
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// command returns the command to run, wrapped in a shell setting the memory limit if MaxMemory is set.
+func (p *PipeExec) command(ctx context.Context) *exec.Cmd {
+	if p.MaxMemory <= 0 {
+		return exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	}
+
+	kb := strconv.FormatInt((p.MaxMemory+1023)/1024, 10)
+	args := append([]string{"-c", `ulimit -v "$1" && shift && exec "$@"`, "sh", kb}, p.Command...)
+	return exec.CommandContext(ctx, "/bin/sh", args...)
+}
+
 func (p *PipeExec) run(ctx context.Context, stdout io.Writer, stdin io.Reader) error {
 	span := trace.GetSpanFromContext(ctx)
 	if span != nil {
 		span.AddField("command", p.Command)
 	}
 
-	cmd := exec.Command(p.Command[0], p.Command[1:]...)
+	execErr := &ExecError{Command: strings.Join(p.Command, " "), ExitCode: -1}
+	if len(p.Command) == 0 || p.Command[0] == "" {
+		execErr.Err = fmt.Errorf("no command specified")
+		return execErr
+	}
+
+	var cancel context.CancelFunc
+	if p.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	cmd := p.command(ctx)
+	cmd.Dir = p.Dir
+	cmd.Env = p.environ()
+	stderr := &limitedBuffer{limit: maxStderrSize}
+	cmd.Stderr = stderr
+
 	stdinPipe, err := cmd.StdinPipe()
 	if err != nil {
-		return err
+		execErr.Err = err
+		return execErr
 	}
 
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
-		return err
+		execErr.Err = err
+		return execErr
 	}
 
 	err = cmd.Start()
 	if err != nil {
-		return err
+		execErr.Err = err
+		return execErr
 	}
 
 	go func() {
@@ -64,6 +137,10 @@ func (p *PipeExec) run(ctx context.Context, stdout io.Writer, stdin io.Reader) e
 		stdinPipe.Close()
 	}()
 
+	var outputErr error
+	if p.MaxOutputSize > 0 {
+		stdout = &limitedWriter{w: stdout, limit: p.MaxOutputSize}
+	}
 	done := make(chan struct{})
 	go func() {
 		copiedFromStdOut, err := io.Copy(stdout, stdoutPipe)
@@ -71,12 +148,75 @@ func (p *PipeExec) run(ctx context.Context, stdout io.Writer, stdin io.Reader) e
 			span.AddField("stdout.bytes_copied", copiedFromStdOut)
 			span.AddField("stdout.err", err)
 		}
-		stdoutPipe.Close()
+		if err != nil {
+			// stop the command rather than waiting for it to finish writing output we can't use
+			outputErr = err
+			cancel()
+			io.Copy(ioutil.Discard, stdoutPipe)
+		}
 		close(done)
 	}()
 
 	<-done
 	err = cmd.Wait()
 
-	return err
+	execErr.Stderr = strings.TrimSpace(stderr.String())
+	if cmd.ProcessState != nil {
+		execErr.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if span != nil {
+		span.AddField("exit_code", execErr.ExitCode)
+		span.AddField("stderr", execErr.Stderr)
+	}
+
+	switch {
+	case outputErr != nil:
+		execErr.Err = outputErr
+	case ctx.Err() == context.DeadlineExceeded:
+		execErr.Err = fmt.Errorf("command timed out after %s", p.Timeout)
+	case ctx.Err() != nil:
+		execErr.Err = ctx.Err()
+	case err != nil:
+		execErr.Err = err
+	default:
+		return nil
+	}
+
+	if span != nil {
+		span.AddField("error", execErr.Err.Error())
+	}
+	return execErr
+}
+
+// limitedWriter returns an error once more than limit bytes have been written to w.
+type limitedWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.written+int64(len(p)) > l.limit {
+		return 0, fmt.Errorf("output exceeds the limit of %d bytes", l.limit)
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.Buffer.Write(p[:remaining])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
 }
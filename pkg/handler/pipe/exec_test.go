@@ -4,7 +4,11 @@ import (
 	"context"
 	"io/ioutil"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPipeExec(t *testing.T) {
@@ -30,3 +34,125 @@ func TestPipeExec(t *testing.T) {
 		t.Fatalf("Content-type not set correctly: %s", r.Header.Get("Content-type"))
 	}
 }
+
+func transformString(p *PipeExec, ctx context.Context, input string) (string, error) {
+	rec := httptest.NewRecorder()
+	rec.Write([]byte(input))
+	r := rec.Result()
+	if err := p.Transform(ctx, r); err != nil {
+		return "", err
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	return string(body), err
+}
+
+func TestPipeExecStderr(t *testing.T) {
+	p := &PipeExec{Command: []string{"/bin/sh", "-c", "echo broken config >&2; exit 3"}}
+	_, err := transformString(p, context.Background(), "")
+	execErr, ok := err.(*ExecError)
+	if !ok {
+		t.Fatalf("Expected an ExecError, got: %v", err)
+	}
+
+	if execErr.ExitCode != 3 {
+		t.Errorf("Wrong exit code: got %d, expected 3", execErr.ExitCode)
+	}
+
+	if execErr.Stderr != "broken config" {
+		t.Errorf("Stderr not captured: %q", execErr.Stderr)
+	}
+
+	if !strings.Contains(err.Error(), "broken config") {
+		t.Errorf("Stderr not included in the error message: %v", err)
+	}
+}
+
+func TestPipeExecTimeout(t *testing.T) {
+	p := &PipeExec{Command: []string{"/bin/sleep", "10"}, Timeout: 50 * time.Millisecond}
+	start := time.Now()
+	_, err := transformString(p, context.Background(), "")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout error, got: %v", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Command wasn't killed after the timeout")
+	}
+}
+
+func TestPipeExecCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p := &PipeExec{Command: []string{"/bin/sleep", "10"}}
+	if _, err := transformString(p, ctx, ""); err == nil {
+		t.Errorf("Expected an error running a command with a canceled context")
+	}
+}
+
+func TestPipeExecEnv(t *testing.T) {
+	os.Setenv("PIPE_EXEC_ALLOWED", "allowed")
+	os.Setenv("PIPE_EXEC_SECRET", "secret")
+	defer os.Unsetenv("PIPE_EXEC_ALLOWED")
+	defer os.Unsetenv("PIPE_EXEC_SECRET")
+
+	p := &PipeExec{
+		Command: []string{"/bin/sh", "-c", `echo "$PIPE_EXEC_ALLOWED:$PIPE_EXEC_SECRET:$PIPE_EXEC_SET"`},
+		Env:     []string{"PIPE_EXEC_ALLOWED", "PIPE_EXEC_SET=set"},
+	}
+	out, err := transformString(p, context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unable to run command: %v", err)
+	}
+
+	if out != "allowed::set\n" {
+		t.Errorf("Wrong environment passed to command: %q", out)
+	}
+}
+
+func TestPipeExecDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeexec")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "base.json"), []byte("base"), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	p := &PipeExec{Command: []string{"/bin/cat", "base.json"}, Dir: dir}
+	out, err := transformString(p, context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unable to run command: %v", err)
+	}
+
+	if out != "base" {
+		t.Errorf("Command not run in the configured directory: %q", out)
+	}
+}
+
+func TestPipeExecMaxOutputSize(t *testing.T) {
+	p := &PipeExec{Command: []string{"/bin/cat"}, MaxOutputSize: 16}
+	if out, err := transformString(p, context.Background(), "small"); err != nil || out != "small" {
+		t.Errorf("Output under the limit failed: %q, %v", out, err)
+	}
+
+	_, err := transformString(p, context.Background(), strings.Repeat("x", 64*1024))
+	if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Errorf("Expected an output limit error, got: %v", err)
+	}
+}
+
+func TestPipeExecMaxMemory(t *testing.T) {
+	p := &PipeExec{Command: []string{"/bin/sh", "-c", "ulimit -v"}, MaxMemory: 512 * 1024 * 1024}
+	out, err := transformString(p, context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unable to run command: %v", err)
+	}
+
+	if strings.TrimSpace(out) != "524288" {
+		t.Errorf("Memory limit not applied: %q", out)
+	}
+}
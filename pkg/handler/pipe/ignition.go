@@ -0,0 +1,433 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func init() {
+	RegisterNative("ct", "application/json", newContainerLinuxTranspiler)
+}
+
+// IgnitionVersion is the version of the Ignition configs produced by the ct transform.
+const IgnitionVersion = "2.2.0"
+
+// The clc types describe the subset of the Container Linux Config format understood by the ct transform.
+type clcConfig struct {
+	Ignition *clcIgnition `yaml:"ignition"`
+	Storage  *clcStorage  `yaml:"storage"`
+	Systemd  *clcSystemd  `yaml:"systemd"`
+	Networkd *clcNetworkd `yaml:"networkd"`
+	Passwd   *clcPasswd   `yaml:"passwd"`
+}
+
+type clcIgnition struct {
+	Config struct {
+		Append  []clcResource `yaml:"append"`
+		Replace *clcResource  `yaml:"replace"`
+	} `yaml:"config"`
+}
+
+type clcResource struct {
+	Source       string           `yaml:"source"`
+	Verification *clcVerification `yaml:"verification"`
+}
+
+type clcVerification struct {
+	Hash struct {
+		Function string `yaml:"function"`
+		Sum      string `yaml:"sum"`
+	} `yaml:"hash"`
+}
+
+type clcOwner struct {
+	ID   *int   `yaml:"id"`
+	Name string `yaml:"name"`
+}
+
+type clcNode struct {
+	Filesystem string    `yaml:"filesystem"`
+	Path       string    `yaml:"path"`
+	User       *clcOwner `yaml:"user"`
+	Group      *clcOwner `yaml:"group"`
+	Overwrite  *bool     `yaml:"overwrite"`
+}
+
+type clcFile struct {
+	clcNode  `yaml:",inline"`
+	Mode     *int `yaml:"mode"`
+	Append   bool `yaml:"append"`
+	Contents struct {
+		Inline string `yaml:"inline"`
+		Remote *struct {
+			URL          string           `yaml:"url"`
+			Compression  string           `yaml:"compression"`
+			Verification *clcVerification `yaml:"verification"`
+		} `yaml:"remote"`
+	} `yaml:"contents"`
+}
+
+type clcDirectory struct {
+	clcNode `yaml:",inline"`
+	Mode    *int `yaml:"mode"`
+}
+
+type clcLink struct {
+	clcNode `yaml:",inline"`
+	Target  string `yaml:"target"`
+	Hard    bool   `yaml:"hard"`
+}
+
+type clcStorage struct {
+	Files       []clcFile      `yaml:"files"`
+	Directories []clcDirectory `yaml:"directories"`
+	Links       []clcLink      `yaml:"links"`
+}
+
+type clcUnit struct {
+	Name     string `yaml:"name"`
+	Enabled  *bool  `yaml:"enabled"`
+	Mask     bool   `yaml:"mask"`
+	Contents string `yaml:"contents"`
+	Dropins  []struct {
+		Name     string `yaml:"name"`
+		Contents string `yaml:"contents"`
+	} `yaml:"dropins"`
+}
+
+type clcSystemd struct {
+	Units []clcUnit `yaml:"units"`
+}
+
+type clcNetworkd struct {
+	Units []struct {
+		Name     string `yaml:"name"`
+		Contents string `yaml:"contents"`
+	} `yaml:"units"`
+}
+
+type clcUser struct {
+	Name              string   `yaml:"name"`
+	PasswordHash      string   `yaml:"password_hash"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys"`
+	UID               *int     `yaml:"uid"`
+	Gecos             string   `yaml:"gecos"`
+	HomeDir           string   `yaml:"home_dir"`
+	NoCreateHome      bool     `yaml:"no_create_home"`
+	PrimaryGroup      string   `yaml:"primary_group"`
+	Groups            []string `yaml:"groups"`
+	NoUserGroup       bool     `yaml:"no_user_group"`
+	System            bool     `yaml:"system"`
+	NoLogInit         bool     `yaml:"no_log_init"`
+	Shell             string   `yaml:"shell"`
+}
+
+type clcGroup struct {
+	Name         string `yaml:"name"`
+	Gid          *int   `yaml:"gid"`
+	PasswordHash string `yaml:"password_hash"`
+	System       bool   `yaml:"system"`
+}
+
+type clcPasswd struct {
+	Users  []clcUser  `yaml:"users"`
+	Groups []clcGroup `yaml:"groups"`
+}
+
+// verificationHash returns the hash in the function-sum form used by Ignition.
+func verificationHash(v *clcVerification) map[string]interface{} {
+	if v == nil || v.Hash.Function == "" {
+		return nil
+	}
+	return map[string]interface{}{"hash": v.Hash.Function + "-" + v.Hash.Sum}
+}
+
+func ignitionResource(r clcResource) map[string]interface{} {
+	resource := map[string]interface{}{"source": r.Source}
+	if v := verificationHash(r.Verification); v != nil {
+		resource["verification"] = v
+	}
+	return resource
+}
+
+func ignitionOwner(o *clcOwner) map[string]interface{} {
+	owner := make(map[string]interface{})
+	if o.ID != nil {
+		owner["id"] = *o.ID
+	}
+	if o.Name != "" {
+		owner["name"] = o.Name
+	}
+	return owner
+}
+
+// ignitionNode converts the fields shared by files, directories and links.  The filesystem defaults to root.
+func ignitionNode(n clcNode) (map[string]interface{}, error) {
+	if n.Path == "" {
+		return nil, fmt.Errorf("storage entries must have a path")
+	}
+
+	node := map[string]interface{}{"filesystem": "root", "path": n.Path}
+	if n.Filesystem != "" {
+		node["filesystem"] = n.Filesystem
+	}
+	if n.User != nil {
+		node["user"] = ignitionOwner(n.User)
+	}
+	if n.Group != nil {
+		node["group"] = ignitionOwner(n.Group)
+	}
+	if n.Overwrite != nil {
+		node["overwrite"] = *n.Overwrite
+	}
+	return node, nil
+}
+
+func ignitionStorage(s *clcStorage) (map[string]interface{}, error) {
+	storage := make(map[string]interface{})
+
+	files := make([]interface{}, 0, len(s.Files))
+	for _, f := range s.Files {
+		file, err := ignitionNode(f.clcNode)
+		if err != nil {
+			return nil, err
+		}
+
+		contents := map[string]interface{}{"source": "data:," + url.PathEscape(f.Contents.Inline), "verification": map[string]interface{}{}}
+		if remote := f.Contents.Remote; remote != nil {
+			if f.Contents.Inline != "" {
+				return nil, fmt.Errorf("file %s has both inline and remote contents", f.Path)
+			}
+			contents["source"] = remote.URL
+			if remote.Compression != "" {
+				contents["compression"] = remote.Compression
+			}
+			if v := verificationHash(remote.Verification); v != nil {
+				contents["verification"] = v
+			}
+		}
+		file["contents"] = contents
+		if f.Mode != nil {
+			file["mode"] = *f.Mode
+		}
+		if f.Append {
+			file["append"] = true
+		}
+		files = append(files, file)
+	}
+
+	directories := make([]interface{}, 0, len(s.Directories))
+	for _, d := range s.Directories {
+		directory, err := ignitionNode(d.clcNode)
+		if err != nil {
+			return nil, err
+		}
+		if d.Mode != nil {
+			directory["mode"] = *d.Mode
+		}
+		directories = append(directories, directory)
+	}
+
+	links := make([]interface{}, 0, len(s.Links))
+	for _, l := range s.Links {
+		link, err := ignitionNode(l.clcNode)
+		if err != nil {
+			return nil, err
+		}
+		link["target"] = l.Target
+		if l.Hard {
+			link["hard"] = true
+		}
+		links = append(links, link)
+	}
+
+	if len(files) > 0 {
+		storage["files"] = files
+	}
+	if len(directories) > 0 {
+		storage["directories"] = directories
+	}
+	if len(links) > 0 {
+		storage["links"] = links
+	}
+	return storage, nil
+}
+
+func ignitionSystemd(s *clcSystemd) map[string]interface{} {
+	units := make([]interface{}, 0, len(s.Units))
+	for _, u := range s.Units {
+		unit := map[string]interface{}{"name": u.Name}
+		if u.Enabled != nil {
+			unit["enabled"] = *u.Enabled
+		}
+		if u.Mask {
+			unit["mask"] = true
+		}
+		if u.Contents != "" {
+			unit["contents"] = u.Contents
+		}
+		if len(u.Dropins) > 0 {
+			dropins := make([]interface{}, 0, len(u.Dropins))
+			for _, d := range u.Dropins {
+				dropins = append(dropins, map[string]interface{}{"name": d.Name, "contents": d.Contents})
+			}
+			unit["dropins"] = dropins
+		}
+		units = append(units, unit)
+	}
+	return map[string]interface{}{"units": units}
+}
+
+func ignitionNetworkd(n *clcNetworkd) map[string]interface{} {
+	units := make([]interface{}, 0, len(n.Units))
+	for _, u := range n.Units {
+		units = append(units, map[string]interface{}{"name": u.Name, "contents": u.Contents})
+	}
+	return map[string]interface{}{"units": units}
+}
+
+func ignitionPasswd(p *clcPasswd) map[string]interface{} {
+	passwd := make(map[string]interface{})
+
+	users := make([]interface{}, 0, len(p.Users))
+	for _, u := range p.Users {
+		user := map[string]interface{}{"name": u.Name}
+		optional := map[string]interface{}{
+			"passwordHash": u.PasswordHash, "gecos": u.Gecos, "homeDir": u.HomeDir,
+			"primaryGroup": u.PrimaryGroup, "shell": u.Shell,
+		}
+		for key, value := range optional {
+			if value != "" {
+				user[key] = value
+			}
+		}
+		flags := map[string]bool{"noCreateHome": u.NoCreateHome, "noUserGroup": u.NoUserGroup, "system": u.System, "noLogInit": u.NoLogInit}
+		for key, value := range flags {
+			if value {
+				user[key] = true
+			}
+		}
+		if len(u.SSHAuthorizedKeys) > 0 {
+			user["sshAuthorizedKeys"] = u.SSHAuthorizedKeys
+		}
+		if len(u.Groups) > 0 {
+			user["groups"] = u.Groups
+		}
+		if u.UID != nil {
+			user["uid"] = *u.UID
+		}
+		users = append(users, user)
+	}
+
+	groups := make([]interface{}, 0, len(p.Groups))
+	for _, g := range p.Groups {
+		group := map[string]interface{}{"name": g.Name}
+		if g.Gid != nil {
+			group["gid"] = *g.Gid
+		}
+		if g.PasswordHash != "" {
+			group["passwordHash"] = g.PasswordHash
+		}
+		if g.System {
+			group["system"] = true
+		}
+		groups = append(groups, group)
+	}
+
+	if len(users) > 0 {
+		passwd["users"] = users
+	}
+	if len(groups) > 0 {
+		passwd["groups"] = groups
+	}
+	return passwd
+}
+
+// transpile converts a Container Linux Config into an Ignition config.
+func transpile(cfg *clcConfig) (map[string]interface{}, error) {
+	ignition := map[string]interface{}{"version": IgnitionVersion}
+	if cfg.Ignition != nil {
+		config := make(map[string]interface{})
+		if len(cfg.Ignition.Config.Append) > 0 {
+			appends := make([]interface{}, 0, len(cfg.Ignition.Config.Append))
+			for _, r := range cfg.Ignition.Config.Append {
+				appends = append(appends, ignitionResource(r))
+			}
+			config["append"] = appends
+		}
+		if cfg.Ignition.Config.Replace != nil {
+			config["replace"] = ignitionResource(*cfg.Ignition.Config.Replace)
+		}
+		ignition["config"] = config
+	}
+
+	out := map[string]interface{}{"ignition": ignition}
+	if cfg.Storage != nil {
+		storage, err := ignitionStorage(cfg.Storage)
+		if err != nil {
+			return nil, err
+		}
+		out["storage"] = storage
+	}
+	if cfg.Systemd != nil {
+		out["systemd"] = ignitionSystemd(cfg.Systemd)
+	}
+	if cfg.Networkd != nil {
+		out["networkd"] = ignitionNetworkd(cfg.Networkd)
+	}
+	if cfg.Passwd != nil {
+		out["passwd"] = ignitionPasswd(cfg.Passwd)
+	}
+	return out, nil
+}
+
+// newContainerLinuxTranspiler converts Container Linux Configs to Ignition configs, like the ct command.  Only
+// the storage files, directories and links, systemd and networkd units, passwd and ignition config sections
+// are supported.  Any other key, such as storage.disks, etcd or docker, is an error rather than being dropped
+// from the output, as is dynamic data such as {PLATFORM}, since platforms aren't supported.  Use the ct command
+// for configs that need them.  --strict is accepted for compatibility, --pretty indents the output.
+func newContainerLinuxTranspiler(_ string, args []string) (TransformFunc, error) {
+	var pretty bool
+	for _, arg := range args {
+		switch arg {
+		case "--strict":
+		case "--pretty":
+			pretty = true
+		default:
+			return nil, fmt.Errorf("unsupported argument: %s", arg)
+		}
+	}
+
+	return func(w io.Writer, r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Contains(data, []byte("{PLATFORM}")) {
+			return fmt.Errorf("unable to parse container linux config: {PLATFORM} substitution isn't supported")
+		}
+
+		cfg := &clcConfig{}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return fmt.Errorf("unable to parse container linux config: %v", err)
+		}
+
+		ignition, err := transpile(cfg)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(w)
+		if pretty {
+			enc.SetIndent("", "  ")
+		}
+		return enc.Encode(ignition)
+	}, nil
+}
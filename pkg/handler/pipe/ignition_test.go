@@ -0,0 +1,127 @@
+package pipe
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestNativeContainerLinuxConfig(t *testing.T) {
+	clc := `
+ignition:
+  config:
+    append:
+      - source: https://boot.local/extra.ign
+        verification:
+          hash:
+            function: sha512
+            sum: abc123
+storage:
+  files:
+    - path: /etc/hostname
+      mode: 0644
+      contents:
+        inline: node 1
+    - path: /opt/bin/tool
+      filesystem: opt
+      mode: 0755
+      user:
+        name: core
+      contents:
+        remote:
+          url: https://boot.local/tool.gz
+          compression: gzip
+  directories:
+    - path: /var/lib/data
+      mode: 0700
+  links:
+    - path: /etc/localtime
+      target: /usr/share/zoneinfo/UTC
+systemd:
+  units:
+    - name: etcd-member.service
+      enabled: true
+      dropins:
+        - name: 20-clct-etcd-member.conf
+          contents: "[Service]"
+    - name: locksmithd.service
+      mask: true
+networkd:
+  units:
+    - name: 00-eth0.network
+      contents: "[Match]"
+passwd:
+  users:
+    - name: core
+      ssh_authorized_keys:
+        - ssh-rsa AAAA
+      groups: [sudo]
+`
+
+	out, contentType := runNative(t, "ct", "", []string{"--strict"}, clc)
+	if contentType != "application/json" {
+		t.Errorf("Wrong content type: %s", contentType)
+	}
+
+	expected := map[string]interface{}{
+		"ignition": map[string]interface{}{
+			"version": "2.2.0",
+			"config": map[string]interface{}{
+				"append": []interface{}{map[string]interface{}{"source": "https://boot.local/extra.ign", "verification": map[string]interface{}{"hash": "sha512-abc123"}}},
+			},
+		},
+		"storage": map[string]interface{}{
+			"files": []interface{}{
+				map[string]interface{}{"filesystem": "root", "path": "/etc/hostname", "mode": 420.0,
+					"contents": map[string]interface{}{"source": "data:,node%201", "verification": map[string]interface{}{}}},
+				map[string]interface{}{"filesystem": "opt", "path": "/opt/bin/tool", "mode": 493.0, "user": map[string]interface{}{"name": "core"},
+					"contents": map[string]interface{}{"source": "https://boot.local/tool.gz", "compression": "gzip", "verification": map[string]interface{}{}}},
+			},
+			"directories": []interface{}{map[string]interface{}{"filesystem": "root", "path": "/var/lib/data", "mode": 448.0}},
+			"links":       []interface{}{map[string]interface{}{"filesystem": "root", "path": "/etc/localtime", "target": "/usr/share/zoneinfo/UTC"}},
+		},
+		"systemd": map[string]interface{}{
+			"units": []interface{}{
+				map[string]interface{}{"name": "etcd-member.service", "enabled": true,
+					"dropins": []interface{}{map[string]interface{}{"name": "20-clct-etcd-member.conf", "contents": "[Service]"}}},
+				map[string]interface{}{"name": "locksmithd.service", "mask": true},
+			},
+		},
+		"networkd": map[string]interface{}{"units": []interface{}{map[string]interface{}{"name": "00-eth0.network", "contents": "[Match]"}}},
+		"passwd": map[string]interface{}{
+			"users": []interface{}{map[string]interface{}{"name": "core", "sshAuthorizedKeys": []interface{}{"ssh-rsa AAAA"}, "groups": []interface{}{"sudo"}}},
+		},
+	}
+
+	if diff := deep.Equal(decodeJSON(t, out), expected); diff != nil {
+		t.Errorf("Wrong ignition config: %v", diff)
+	}
+}
+
+func TestNativeContainerLinuxConfigUnsupported(t *testing.T) {
+	p, err := NewNativePipe("ct", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create transform: %v", err)
+	}
+
+	cases := map[string]string{
+		"unknown key": "storage:\n  filez: []\n",
+		"disks":       "storage:\n  disks:\n    - device: /dev/sda\n",
+		"filesystems": "storage:\n  filesystems:\n    - name: data\n",
+		"etcd":        "etcd:\n  name: node1\n",
+		"docker":      "docker:\n  flags: [--debug]\n",
+		"platform":    "storage:\n  files:\n    - path: /etc/platform\n      contents:\n        inline: \"{PLATFORM}\"\n",
+	}
+
+	for name, clc := range cases {
+		if err := p.Func(ioutil.Discard, strings.NewReader(clc)); err == nil {
+			t.Errorf("%s: expected an error for an unsupported config", name)
+		}
+	}
+
+	if _, err := NewNativePipe("ct", "", []string{"--platform=ec2"}); err == nil {
+		t.Errorf("Expected an error for an unsupported argument")
+	}
+}
@@ -0,0 +1,108 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/honeycombio/beeline-go/trace"
+)
+
+// TransformFunc reads the body of a response from r and writes the transformed body to w.
+type TransformFunc func(w io.Writer, r io.Reader) error
+
+// NativeFactory creates a TransformFunc from the arguments given in the config.  Relative paths in args are
+// resolved against dir.
+type NativeFactory func(dir string, args []string) (TransformFunc, error)
+
+type nativeTransform struct {
+	factory     NativeFactory
+	contentType string
+}
+
+var nativeTransforms = map[string]nativeTransform{}
+
+// RegisterNative makes a transform available by name.  contentType is the default content type of its output,
+// an empty string leaves the content type unchanged.
+func RegisterNative(name string, contentType string, factory NativeFactory) {
+	nativeTransforms[name] = nativeTransform{factory: factory, contentType: contentType}
+}
+
+// IsNative returns true if name is a registered native transform.
+func IsNative(name string) bool {
+	_, ok := nativeTransforms[name]
+	return ok
+}
+
+// NativeNames returns the names of the registered native transforms.
+func NativeNames() []string {
+	names := make([]string, 0, len(nativeTransforms))
+	for name := range nativeTransforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NativePipe transforms responses in process.
+type NativePipe struct {
+	Name        string
+	ContentType string
+	Func        TransformFunc
+}
+
+// NewNativePipe returns a NativePipe for the named transform.
+func NewNativePipe(name string, dir string, args []string) (*NativePipe, error) {
+	native, ok := nativeTransforms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown native transform: %s", name)
+	}
+
+	f, err := native.factory(dir, args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid arguments for %s: %v", name, err)
+	}
+	return &NativePipe{Name: name, ContentType: native.contentType, Func: f}, nil
+}
+
+func (p *NativePipe) Transform(ctx context.Context, r *http.Response) error {
+	var out bytes.Buffer
+	if err := p.Stream(ctx, &out, r.Body, r.Header); err != nil {
+		return err
+	}
+
+	r.Body = ioutil.NopCloser(&out)
+	return nil
+}
+
+// Stream transforms r as it's read, writing the result to w.
+func (p *NativePipe) Stream(ctx context.Context, w io.Writer, r io.Reader, header http.Header) error {
+	span := trace.GetSpanFromContext(ctx)
+	if span != nil {
+		span.AddField("transform", p.Name)
+	}
+
+	if p.ContentType != "" {
+		header.Set("Content-type", p.ContentType)
+	}
+
+	if err := p.Func(w, r); err != nil {
+		if span != nil {
+			span.AddField("error", err.Error())
+		}
+		return &NativeError{Transform: p.Name, Err: err}
+	}
+	return nil
+}
+
+// noArgs returns an error if any arguments were given.
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("no arguments expected, got %v", args)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package pipe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func runNative(t *testing.T, name string, dir string, args []string, input string) (string, string) {
+	p, err := NewNativePipe(name, dir, args)
+	if err != nil {
+		t.Fatalf("Unable to create %s transform: %v", name, err)
+	}
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-type", "text/plain")
+	rec.Write([]byte(input))
+	r := rec.Result()
+	if err := p.Transform(context.Background(), r); err != nil {
+		t.Fatalf("Unable to run %s transform: %v", name, err)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatalf("Unable to read body: %v", err)
+	}
+	return string(body), r.Header.Get("Content-type")
+}
+
+func decodeJSON(t *testing.T, data string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("Unable to parse json %q: %v", data, err)
+	}
+	return value
+}
+
+func TestNativeYAMLToJSON(t *testing.T) {
+	out, contentType := runNative(t, "yaml2json", "", nil, "foo:\n  bar: [1, 2]\n")
+	if diff := deep.Equal(decodeJSON(t, out), map[string]interface{}{"foo": map[string]interface{}{"bar": []interface{}{1.0, 2.0}}}); diff != nil {
+		t.Errorf("Wrong json produced: %v", diff)
+	}
+
+	if contentType != "application/json" {
+		t.Errorf("Wrong content type: %s", contentType)
+	}
+}
+
+func TestNativeJSONToYAML(t *testing.T) {
+	out, contentType := runNative(t, "json2yaml", "", nil, `{"foo": {"bar": "baz"}}`)
+	if out != "foo:\n  bar: baz\n" {
+		t.Errorf("Wrong yaml produced: %q", out)
+	}
+
+	if contentType != "application/x-yaml" {
+		t.Errorf("Wrong content type: %s", contentType)
+	}
+}
+
+func TestNativeJSONMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonmerge")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := `{"ignition": {"version": "2.2.0"}, "storage": {"files": []}, "passwd": {"users": [{"name": "core"}]}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "base.json"), []byte(base), 0644); err != nil {
+		t.Fatalf("Unable to write file: %v", err)
+	}
+
+	out, _ := runNative(t, "jsonmerge", dir, []string{"base.json"}, `{"storage": {"disks": []}, "passwd": {"users": [{"name": "admin"}]}}`)
+	expected := map[string]interface{}{
+		"ignition": map[string]interface{}{"version": "2.2.0"},
+		"storage":  map[string]interface{}{"files": []interface{}{}, "disks": []interface{}{}},
+		"passwd":   map[string]interface{}{"users": []interface{}{map[string]interface{}{"name": "admin"}}},
+	}
+	if diff := deep.Equal(decodeJSON(t, out), expected); diff != nil {
+		t.Errorf("Wrong merged json: %v", diff)
+	}
+
+	for _, args := range [][]string{nil, {"../base.json"}, {"/etc/passwd"}, {"missing.json"}} {
+		if _, err := NewNativePipe("jsonmerge", dir, args); err == nil {
+			t.Errorf("Expected an error for jsonmerge arguments %v", args)
+		}
+	}
+}
+
+func TestNativeGzip(t *testing.T) {
+	out, contentType := runNative(t, "gzip", "", []string{"-c", "-9"}, "Hello world!")
+	gz, err := gzip.NewReader(bytes.NewBufferString(out))
+	if err != nil {
+		t.Fatalf("Output isn't gzipped: %v", err)
+	}
+
+	data, err := ioutil.ReadAll(gz)
+	if err != nil || string(data) != "Hello world!" {
+		t.Errorf("Wrong data decompressed: %q, %v", data, err)
+	}
+
+	if contentType != "application/gzip" {
+		t.Errorf("Wrong content type: %s", contentType)
+	}
+
+	if _, err := NewNativePipe("gzip", "", []string{"--best"}); err == nil {
+		t.Errorf("Expected an error for an unsupported argument")
+	}
+}
+
+func TestNativeBase64(t *testing.T) {
+	out, _ := runNative(t, "base64", "", nil, "Hello world!")
+	if out != "SGVsbG8gd29ybGQh" {
+		t.Errorf("Wrong encoding: %q", out)
+	}
+
+	out, _ = runNative(t, "base64", "", []string{"-d"}, "SGVsbG8g\nd29ybGQh\n")
+	if out != "Hello world!" {
+		t.Errorf("Wrong decoding: %q", out)
+	}
+}
+
+func TestNativeUnknown(t *testing.T) {
+	if IsNative("cat") {
+		t.Errorf("cat shouldn't be a native transform")
+	}
+
+	if _, err := NewNativePipe("cat", "", nil); err == nil {
+		t.Errorf("Expected an error for an unknown transform")
+	}
+
+	if _, err := NewNativePipe("yaml2json", "", []string{"-x"}); err == nil {
+		t.Errorf("Expected an error for unexpected arguments")
+	}
+}
+
+func TestNativeTransformError(t *testing.T) {
+	p, err := NewNativePipe("json2yaml", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create transform: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	rec.Write([]byte("not json"))
+	err = p.Transform(context.Background(), rec.Result())
+	if err == nil || !strings.Contains(err.Error(), "json2yaml") {
+		t.Errorf("Expected an error naming the transform, got: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// StreamingPipe is implemented by ResponsePipes that can transform a response as it's produced.  Stream reads
+// the response body from r and writes the transformed body to w.  Changes to header must be made before the
+// first write to w.
+type StreamingPipe interface {
+	Stream(ctx context.Context, w io.Writer, r io.Reader, header http.Header) error
+}
+
+// streamWriter is the http.ResponseWriter given to the wrapped handler when streaming.  The body is written to
+// an io.Pipe, the status and headers are made available once the handler writes the header or any of the body.
+type streamWriter struct {
+	header http.Header
+	pw     *io.PipeWriter
+
+	once   sync.Once
+	ready  chan struct{}
+	status int
+	sent   http.Header
+}
+
+func newStreamWriter(pw *io.PipeWriter) *streamWriter {
+	return &streamWriter{header: make(http.Header), pw: pw, ready: make(chan struct{}), status: http.StatusOK}
+}
+
+func (s *streamWriter) Header() http.Header {
+	return s.header
+}
+
+// WriteHeader records the status and a copy of the headers, the wrapped handler may keep changing its own copy.
+func (s *streamWriter) WriteHeader(status int) {
+	s.once.Do(func() {
+		s.status = status
+		s.sent = make(http.Header, len(s.header))
+		for name, values := range s.header {
+			s.sent[name] = append([]string(nil), values...)
+		}
+		close(s.ready)
+	})
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	return s.pw.Write(p)
+}
+
+// lazyWriter writes the status and headers to the client before the first write of the body.
+type lazyWriter struct {
+	w       http.ResponseWriter
+	status  int
+	written bool
+}
+
+func (l *lazyWriter) Write(p []byte) (int, error) {
+	if !l.written {
+		l.written = true
+		l.w.WriteHeader(l.status)
+	}
+	return l.w.Write(p)
+}
+
+// serveStream runs the wrapped handler concurrently, piping its output through p to the client.  Responses
+// without a 2xx status are passed on untransformed.  If the transform fails before writing anything the error
+// is reported as JSON.  Once the response has started the connection is aborted instead, so that clients don't
+// mistake a truncated response for a complete one.
+func (h *PipeHandler) serveStream(w http.ResponseWriter, r *http.Request, p StreamingPipe) {
+	pr, pw := io.Pipe()
+	sw := newStreamWriter(pw)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				pw.CloseWithError(fmt.Errorf("handler aborted: %v", recovered))
+			}
+			sw.WriteHeader(http.StatusOK)
+		}()
+		h.Handler.ServeHTTP(sw, r)
+		pw.Close()
+	}()
+
+	<-sw.ready
+	defer func() {
+		pr.Close()
+		<-done
+	}()
+
+	for header, values := range sw.sent {
+		w.Header()[header] = values
+	}
+
+	if _, raw := r.URL.Query()["raw"]; raw || !successful(sw.status) {
+		w.WriteHeader(sw.status)
+		if _, err := io.Copy(w, pr); err != nil {
+			log.Printf("error streaming raw response: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+
+	// the length and ETag of the transformed body aren't known until it has been sent
+	w.Header().Del("Content-Length")
+	w.Header().Del("ETag")
+	out := &lazyWriter{w: w, status: sw.status}
+	err := p.Stream(r.Context(), out, pr, w.Header())
+	if err == nil {
+		// the transform may not have written anything
+		out.Write(nil)
+		return
+	}
+
+	if out.written {
+		log.Printf("An error ocurred while transforming response: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+	renderTransformError(w, err)
+}
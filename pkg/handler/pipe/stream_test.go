@@ -0,0 +1,170 @@
+package pipe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// notifyingRecorder signals on the first write of the body.
+type notifyingRecorder struct {
+	*httptest.ResponseRecorder
+	first chan struct{}
+	once  bool
+}
+
+func (n *notifyingRecorder) Write(p []byte) (int, error) {
+	if !n.once {
+		n.once = true
+		close(n.first)
+	}
+	return n.ResponseRecorder.Write(p)
+}
+
+func passthrough(contentType string) *NativePipe {
+	return &NativePipe{Name: "passthrough", ContentType: contentType, Func: func(w io.Writer, r io.Reader) error {
+		_, err := io.Copy(w, r)
+		return err
+	}}
+}
+
+func TestPipeHandlerStream(t *testing.T) {
+	w := &notifyingRecorder{ResponseRecorder: httptest.NewRecorder(), first: make(chan struct{})}
+	inner := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-type", "text/plain")
+		rw.Header().Set("Content-Length", "1000")
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("first "))
+		// the rest of the body isn't produced until the client has seen the start
+		select {
+		case <-w.first:
+		case <-time.After(5 * time.Second):
+		}
+		rw.Write([]byte("second"))
+	})
+
+	h := &PipeHandler{ResponsePipe: passthrough("application/json"), Handler: inner, Stream: true}
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/stream", nil))
+
+	select {
+	case <-w.first:
+	default:
+		t.Fatalf("Nothing written to the client")
+	}
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Wrong status: %d", w.Code)
+	}
+
+	if w.Body.String() != "first second" {
+		t.Errorf("Wrong body: %q", w.Body.String())
+	}
+
+	if w.Header().Get("Content-type") != "application/json" {
+		t.Errorf("Content type not set by the transform: %s", w.Header().Get("Content-type"))
+	}
+
+	if w.Header().Get("Content-Length") != "" {
+		t.Errorf("Content-Length of the untransformed body was sent")
+	}
+}
+
+func TestPipeHandlerStreamExec(t *testing.T) {
+	input := strings.Repeat("0123456789abcdef", 64*1024)
+	inner := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.Copy(rw, strings.NewReader(input))
+	})
+
+	h := &PipeHandler{ResponsePipe: &PipeExec{Command: []string{"/bin/cat"}, ContentType: "text/plain"}, Handler: inner, Stream: true}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/stream", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != input {
+		t.Errorf("Wrong response: %d, %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+func TestPipeHandlerStreamRaw(t *testing.T) {
+	h := &PipeHandler{ResponsePipe: passthrough("application/json"), Handler: &loopbackHandler{}, Stream: true}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/stream?raw", bytes.NewBufferString("Hello world!")))
+
+	if w.Body.String() != "Hello world!" || w.Header().Get("Content-type") != "text/plain" {
+		t.Errorf("Raw response not returned: %q %s", w.Body.String(), w.Header().Get("Content-type"))
+	}
+}
+
+func TestPipeHandlerStreamErrorBeforeOutput(t *testing.T) {
+	failing := &NativePipe{Name: "failing", Func: func(w io.Writer, r io.Reader) error {
+		ioutil.ReadAll(r)
+		return fmt.Errorf("broken")
+	}}
+
+	h := &PipeHandler{ResponsePipe: failing, Handler: &loopbackHandler{}, Stream: true}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/stream", bytes.NewBufferString("Hello world!")))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status: %d", w.Code)
+	}
+}
+
+func TestPipeHandlerStreamErrorAfterOutput(t *testing.T) {
+	failing := &NativePipe{Name: "failing", Func: func(w io.Writer, r io.Reader) error {
+		io.Copy(w, r)
+		return fmt.Errorf("broken")
+	}}
+
+	h := &PipeHandler{ResponsePipe: failing, Handler: &loopbackHandler{}, Stream: true}
+	w := httptest.NewRecorder()
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("Expected the connection to be aborted, got: %v", recovered)
+		}
+	}()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/stream", bytes.NewBufferString("Hello world!")))
+}
+
+func TestPipeHandlerStreamHandlerAborted(t *testing.T) {
+	inner := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	var transformErr error
+	p := &NativePipe{Name: "reader", Func: func(w io.Writer, r io.Reader) error {
+		_, transformErr = ioutil.ReadAll(r)
+		return transformErr
+	}}
+
+	h := &PipeHandler{ResponsePipe: p, Handler: inner, Stream: true}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/stream", nil))
+
+	if transformErr == nil {
+		t.Errorf("Transform didn't see the handler abort")
+	}
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status: %d", w.Code)
+	}
+}
+
+func TestPipeHandlerStreamCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	h := &PipeHandler{ResponsePipe: &PipeExec{Command: []string{"/bin/sleep", "10"}}, Handler: &loopbackHandler{}, Stream: true}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/stream", bytes.NewBufferString("Hello world!")).WithContext(ctx))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Wrong status: %d", w.Code)
+	}
+}
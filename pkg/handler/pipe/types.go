@@ -1,11 +1,17 @@
 package pipe
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 
 	"github.com/honeycombio/beeline-go/trace"
 )
@@ -14,10 +20,13 @@ type ResponsePipe interface {
 	Transform(context.Context, *http.Response) error
 }
 
-// PipeHandler passes the output of the wrapped Handler through the supplied ResponsePipe.
+// PipeHandler passes the output of the wrapped Handler through the supplied ResponsePipe.  The response is
+// buffered in memory unless Stream is set and the ResponsePipe implements StreamingPipe, in which case the
+// output of the Handler is piped through the transform to the client as it's produced.
 type PipeHandler struct {
 	ResponsePipe ResponsePipe
 	Handler      http.Handler
+	Stream       bool
 }
 
 func (h *PipeHandler) copyResponse(w http.ResponseWriter, r *http.Response) (int64, error) {
@@ -39,6 +48,15 @@ func (h *PipeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r = r.WithContext(ctx)
 		span.AddField("name", "PipeHandler")
 	}
+
+	if p, ok := h.ResponsePipe.(StreamingPipe); ok && h.Stream {
+		if span != nil {
+			span.AddField("stream", true)
+		}
+		h.serveStream(w, r, p)
+		return
+	}
+
 	b := httptest.NewRecorder()
 
 	h.Handler.ServeHTTP(b, r)
@@ -46,7 +64,11 @@ func (h *PipeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	response := b.Result()
 	queryValues := r.URL.Query()
 
-	if _, ok := queryValues["raw"]; ok {
+	_, raw := queryValues["raw"]
+	if raw || !successful(response.StatusCode) {
+		if span != nil {
+			span.AddField("transform.skipped", true)
+		}
 		_, err := h.copyResponse(w, response)
 		if err != nil {
 			log.Printf("error replaying raw response: %v", err)
@@ -60,11 +82,29 @@ func (h *PipeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	err := h.ResponsePipe.Transform(r.Context(), response)
 	if err != nil {
-		log.Printf("An error ocurred while transforming response: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		renderTransformError(w, err)
 		return
 	}
 
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		renderTransformError(w, err)
+		return
+	}
+
+	// the headers describing the body must match the transformed body
+	response.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if response.Header.Get("ETag") != "" {
+		etag := bodyETag(body)
+		response.Header.Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			response.Header.Del("Content-Length")
+			response.StatusCode = http.StatusNotModified
+			body = nil
+		}
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
 	count, err := h.copyResponse(w, response)
 	if err != nil {
 		log.Printf("error replaying transformed response: %v", err)
@@ -75,3 +115,25 @@ func (h *PipeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
+// successful returns true for 2xx statuses, other responses are passed on without being transformed.
+func successful(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// bodyETag returns a strong ETag for body.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%x"`, sum[:16])
+}
+
+// etagMatches returns true if etag is listed in the If-None-Match header value.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 )
 
@@ -102,3 +103,115 @@ func TestPipeHandlerRaw(t *testing.T) {
 	}
 
 }
+
+type statusHandler struct {
+	status int
+	header http.Header
+	body   string
+}
+
+func (s *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for name, values := range s.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(s.status)
+	io.WriteString(w, s.body)
+}
+
+func TestPipeHandlerSkipsErrors(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		inner := &statusHandler{status: http.StatusNotFound, header: http.Header{"Content-Type": []string{"application/json"}}, body: `{"msg":"not found"}`}
+		h := &PipeHandler{ResponsePipe: &PipeExec{Command: []string{"/bin/sh", "-c", "echo transformed"}, ContentType: "text/plain"}, Handler: inner, Stream: stream}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/transformer", nil))
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("stream %v: wrong status returned: %d", stream, w.Code)
+		}
+
+		if w.Body.String() != `{"msg":"not found"}` || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("stream %v: error response was transformed: %s %q", stream, w.Header().Get("Content-Type"), w.Body.String())
+		}
+	}
+}
+
+func TestPipeHandlerTransformError(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		h := &PipeHandler{ResponsePipe: &PipeExec{Command: []string{"/bin/sh", "-c", "cat > /dev/null; echo invalid config >&2; exit 2"}}, Handler: &loopbackHandler{}, Stream: stream}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/transformer", bytes.NewBufferString("Hello world!")))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("stream %v: wrong status returned: %d", stream, w.Code)
+		}
+
+		if w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("stream %v: wrong content type: %s", stream, w.Header().Get("Content-Type"))
+		}
+
+		body := make(map[string]interface{})
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("stream %v: unable to decode error: %v", stream, err)
+		}
+
+		if body["command"] != "/bin/sh -c cat > /dev/null; echo invalid config >&2; exit 2" || body["exit_code"] != 2.0 || body["stderr"] != "invalid config" {
+			t.Errorf("stream %v: wrong error returned: %v", stream, body)
+		}
+	}
+}
+
+func TestPipeHandlerNativeError(t *testing.T) {
+	p, err := NewNativePipe("json2yaml", "", nil)
+	if err != nil {
+		t.Fatalf("Unable to create transform: %v", err)
+	}
+
+	h := &PipeHandler{ResponsePipe: p, Handler: &loopbackHandler{}}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/transformer", bytes.NewBufferString("not json")))
+
+	body := make(map[string]interface{})
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Unable to decode error: %v", err)
+	}
+
+	if w.Code != http.StatusInternalServerError || body["transform"] != "json2yaml" {
+		t.Errorf("Wrong error returned: %d %v", w.Code, body)
+	}
+}
+
+func TestPipeHandlerHeaders(t *testing.T) {
+	header := http.Header{"Content-Length": []string{"12"}, "Etag": []string{`"original"`}}
+	inner := &statusHandler{status: http.StatusOK, header: header, body: "Hello world!"}
+	h := &PipeHandler{ResponsePipe: &testTransformer{}, Handler: inner}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/transformer", nil))
+
+	if w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Content-Length doesn't match the transformed body: %s, %d bytes", w.Header().Get("Content-Length"), w.Body.Len())
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" || etag == `"original"` {
+		t.Fatalf("ETag not updated for the transformed body: %s", etag)
+	}
+
+	request := httptest.NewRequest("GET", "/transformer", nil)
+	request.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, request)
+
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Matching ETag didn't return 304: %d", w.Code)
+	}
+
+	h.Stream = true
+	h.ResponsePipe = passthrough("")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/transformer", nil))
+
+	if w.Header().Get("Content-Length") != "" || w.Header().Get("ETag") != "" {
+		t.Errorf("Untransformed headers sent with a streamed response: %v", w.Header())
+	}
+}
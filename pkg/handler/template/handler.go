@@ -1,8 +1,10 @@
 package templatehandler
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -21,6 +23,52 @@ func (e ErrNotFound) Error() string {
 	return fmt.Sprintf("%s", e.Message)
 }
 
+// ErrBadRequest should be returned when the request can't be handled because it is malformed.
+type ErrBadRequest struct {
+	Message string
+}
+
+func (e ErrBadRequest) Error() string {
+	return e.Message
+}
+
+// ResponseStatus returns http.StatusBadRequest.
+func (e ErrBadRequest) ResponseStatus() int {
+	return http.StatusBadRequest
+}
+
+// ResponseBody returns the message in the same format as RenderJsonError.
+func (e ErrBadRequest) ResponseBody() interface{} {
+	return map[string]string{"msg": e.Message}
+}
+
+// ErrRequestTooLarge should be returned when the request body exceeds the size the handler accepts.
+type ErrRequestTooLarge struct {
+	Message string
+}
+
+func (e ErrRequestTooLarge) Error() string {
+	return e.Message
+}
+
+// ResponseStatus returns http.StatusRequestEntityTooLarge.
+func (e ErrRequestTooLarge) ResponseStatus() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// ResponseBody returns the message in the same format as RenderJsonError.
+func (e ErrRequestTooLarge) ResponseBody() interface{} {
+	return map[string]string{"msg": e.Message}
+}
+
+// ResponseError may be implemented by errors returned while rendering that should be reported to the client with a
+// specific status and JSON body, such as an invalid response from an upstream service.
+type ResponseError interface {
+	error
+	ResponseStatus() int
+	ResponseBody() interface{}
+}
+
 // RenderManagers are responsible for choosing the correct template to render and what data to populate it with.  Embed
 // the DefaultRenderManager for basic functionality.
 type RenderManager interface {
@@ -29,6 +77,20 @@ type RenderManager interface {
 	TemplateFuncs() template.FuncMap
 }
 
+// RequestFuncProvider may be implemented by a RenderManager to supply template functions bound to the request being
+// rendered, such as functions that need the request's context.  They replace the functions of the same name returned
+// by TemplateFuncs for the duration of the request.
+type RequestFuncProvider interface {
+	RequestFuncs(*http.Request) template.FuncMap
+}
+
+// DataTemplateSelector may be implemented by a RenderManager whose template selection needs the data returned by
+// GetData, for example to make data looked up while selecting the template available to it.  It's used in place of
+// TemplateSelector.
+type DataTemplateSelector interface {
+	TemplateSelectorWithData(*http.Request, *template.Template, interface{}) (string, error)
+}
+
 // DefaultRenderManager is an implementation of the RenderManager interface that selects the first template available
 // and populates it with whatever data is assigned to the Data element of the DefaultRenderManager.
 type DefaultRenderManager struct {
@@ -51,6 +113,7 @@ type TemplateHandler struct {
 	templatePath string
 	Template     *template.Template
 	Headers      map[string]string
+	Stream       bool
 	RenderManager
 }
 
@@ -99,37 +162,73 @@ func (t *TemplateHandler) renderTemplate(w io.Writer, r *http.Request) error {
 	}
 
 	// Select the template to render
-	template_name, err := t.TemplateSelector(r, t.Template)
+	var template_name string
+	if s, ok := t.RenderManager.(DataTemplateSelector); ok {
+		template_name, err = s.TemplateSelectorWithData(r, t.Template, data)
+	} else {
+		template_name, err = t.TemplateSelector(r, t.Template)
+	}
 	if err != nil {
 		return err
 	}
 
-	return t.Template.ExecuteTemplate(w, template_name, data)
+	tmpl := t.Template
+	if p, ok := t.RenderManager.(RequestFuncProvider); ok {
+		tmpl, err = t.Template.Clone()
+		if err != nil {
+			return err
+		}
+		tmpl.Funcs(p.RequestFuncs(r))
+	}
+
+	return tmpl.ExecuteTemplate(w, template_name, data)
 }
 
 // RenderJsonError writes the provided err to the ResponseWriter in JSON format.
 func RenderJsonError(w http.ResponseWriter, status int, err error) {
-	w.Header().Set("Content-type", "application/json")
-	w.WriteHeader(status)
 	err_body := make(map[string]string)
 	err_body["msg"] = fmt.Sprintf("%v", err)
+	RenderJson(w, status, &err_body)
+}
+
+// RenderJson writes body to the ResponseWriter in JSON format with the provided status.
+func RenderJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
-	enc.Encode(&err_body)
+	enc.Encode(body)
 }
 
-// ServeHTTP handles requests from the user.  Any error raised during the process other than ErrTemplateNotFound results
-// in a 500 status being returned to the user and a more detailed log being written.
-func (t *TemplateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var body bytes.Buffer
-	// render template
-	err := t.renderTemplate(&body, r)
+// renderError writes err to the client.  ErrNotFound results in a 404 and a ResponseError anywhere in the error chain
+// is reported with its own status and body.  Any other error results in a 500 status being returned to the user and a
+// more detailed log being written.
+func (t *TemplateHandler) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	var responseErr ResponseError
 	if _, ok := err.(ErrNotFound); ok {
 		RenderJsonError(w, http.StatusNotFound, err)
 		log.Printf("Not Found: %s", err)
-		return
-	} else if err != nil {
+	} else if errors.As(err, &responseErr) {
+		RenderJson(w, responseErr.ResponseStatus(), responseErr.ResponseBody())
+		log.Printf("An error ocurred while handling %v: %s", r, err)
+	} else {
 		RenderJsonError(w, http.StatusInternalServerError, fmt.Errorf("Internal server error. Please consult the server logs."))
 		log.Printf("An error ocurred while handling %v: %s", r, err)
+	}
+}
+
+// ServeHTTP handles requests from the user, see renderError for how errors are reported.  Unless Stream is set the
+// whole template is rendered before anything is written so that errors are always reported with the right status.
+func (t *TemplateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if t.Stream {
+		t.serveStream(w, r)
+		return
+	}
+
+	var body bytes.Buffer
+	// render template
+	err := t.renderTemplate(&body, r)
+	if err != nil {
+		t.renderError(w, r, err)
 		return
 	}
 	for header, value := range t.Headers {
@@ -140,3 +239,52 @@ func (t *TemplateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Unable to write body to client: %s", err)
 	}
 }
+
+// streamBufferSize is the amount of output buffered before a streamed response is started.  Errors raised before
+// the buffer is first flushed are still reported normally.
+const streamBufferSize = 32 * 1024
+
+// headerWriter sets the handler's headers before the first write to the client.
+type headerWriter struct {
+	w       http.ResponseWriter
+	headers map[string]string
+	started bool
+}
+
+// start sets the headers if that hasn't been done yet.
+func (h *headerWriter) start() {
+	if h.started {
+		return
+	}
+	h.started = true
+	for header, value := range h.headers {
+		h.w.Header().Set(header, value)
+	}
+}
+
+func (h *headerWriter) Write(p []byte) (int, error) {
+	h.start()
+	return h.w.Write(p)
+}
+
+// serveStream writes the template to the client as it's rendered.  If rendering fails after the response has
+// started the connection is aborted, so that clients don't mistake a truncated response for a complete one.
+func (t *TemplateHandler) serveStream(w http.ResponseWriter, r *http.Request) {
+	hw := &headerWriter{w: w, headers: t.Headers}
+	buf := bufio.NewWriterSize(hw, streamBufferSize)
+	err := t.renderTemplate(buf, r)
+	if err == nil {
+		// Templates that render nothing never write to hw, so make sure the headers are still sent.
+		hw.start()
+		if err := buf.Flush(); err != nil {
+			log.Printf("Unable to write body to client: %s", err)
+		}
+		return
+	}
+
+	if hw.started {
+		log.Printf("An error ocurred while streaming %v: %s", r, err)
+		panic(http.ErrAbortHandler)
+	}
+	t.renderError(w, r, err)
+}
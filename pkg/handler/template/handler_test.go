@@ -97,6 +97,49 @@ func TestTemplateFunction(t *testing.T) {
 	}
 }
 
+type RequestFuncRenderManager struct {
+	TestRenderManager
+}
+
+func (m *RequestFuncRenderManager) RequestFuncs(r *http.Request) template.FuncMap {
+	return template.FuncMap{"hello": func(name string) string { return fmt.Sprintf("Hello %s from %s!", name, r.Header.Get("X-Name")) }}
+}
+
+func TestRequestFunction(t *testing.T) {
+	h, err := sampleTemplateHandler("hello")
+	if err != nil {
+		t.Fatalf("Unable to create template for testing: %v", err)
+	}
+	h.RenderManager = &RequestFuncRenderManager{TestRenderManager{TemplateName: "hello"}}
+
+	for _, name := range []string{"alice", "bob"} {
+		r := &http.Request{Header: http.Header{}}
+		r.Header.Set("X-Name", name)
+
+		var b bytes.Buffer
+		err = h.renderTemplate(&b, r)
+		if err != nil {
+			t.Fatalf("Error rendering template: %s", err)
+		}
+
+		expected := fmt.Sprintf("{\"msg\":\"Hello wool from %s!\"}", name)
+		if b.String() != expected {
+			t.Fatalf("Wrong string rendered as output: %s", b.String())
+		}
+	}
+
+	var b bytes.Buffer
+	h.RenderManager = &TestRenderManager{TemplateName: "hello"}
+	err = h.renderTemplate(&b, &http.Request{})
+	if err != nil {
+		t.Fatalf("Error rendering template: %s", err)
+	}
+
+	if b.String() != "{\"msg\":\"Hello wool!\"}" {
+		t.Fatalf("Request functions modified the shared template: %s", b.String())
+	}
+}
+
 func TestRenderJsonError(t *testing.T) {
 	w := httptest.NewRecorder()
 	RenderJsonError(w, http.StatusTeapot, fmt.Errorf("I'm a teapot"))
@@ -202,3 +245,155 @@ func TestServeHTTPServerError(t *testing.T) {
 		t.Errorf("Wrong body returned:\n%s", body["msg"])
 	}
 }
+
+type testResponseError struct{}
+
+func (testResponseError) Error() string       { return "upstream failed" }
+func (testResponseError) ResponseStatus() int { return http.StatusBadGateway }
+func (testResponseError) ResponseBody() interface{} {
+	return map[string]string{"msg": "upstream failed", "field": "Role"}
+}
+
+func TestServeHTTPResponseError(t *testing.T) {
+	rm := &TestRenderManager{TemplateName: "upstream"}
+	tmpl, err := template.New("upstream").Funcs(template.FuncMap{
+		"upstream": func() (string, error) { return "", testResponseError{} },
+	}).Parse("{{ upstream }}")
+	if err != nil {
+		t.Fatalf("Unable to create template for testing: %v", err)
+	}
+	h := &TemplateHandler{Template: tmpl, RenderManager: rm, Headers: map[string]string{}}
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "http://localhost/foo", &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("Unable to create request: %s", err)
+	}
+	h.ServeHTTP(w, r)
+
+	if w.Result().StatusCode != http.StatusBadGateway {
+		t.Errorf("Incorrect status code set: %d", w.Result().StatusCode)
+	}
+
+	dec := json.NewDecoder(w.Result().Body)
+	body := make(map[string]string)
+	dec.Decode(&body)
+
+	if body["msg"] != "upstream failed" || body["field"] != "Role" {
+		t.Errorf("Wrong body returned:\n%v", body)
+	}
+}
+
+func TestServeHTTPStream(t *testing.T) {
+	h, err := sampleTemplateHandler("test")
+	if err != nil {
+		t.Fatalf("Unable to create template for testing: %v", err)
+	}
+	h.Stream = true
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/foo", nil))
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Wrong status or content type: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	if w.Body.String() != "{\"msg\":\"2 items are made of wool\"}" {
+		t.Errorf("Wrong body returned:\n%s", w.Body)
+	}
+}
+
+func TestServeHTTPStreamEmpty(t *testing.T) {
+	h, err := sampleTemplateHandler("empty")
+	if err != nil {
+		t.Fatalf("Unable to create template for testing: %v", err)
+	}
+	_, err = h.Template.New("empty").Parse("")
+	if err != nil {
+		t.Fatalf("Unable to parse template: %v", err)
+	}
+	h.Stream = true
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/foo", nil))
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Wrong status or content type: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	if w.Body.Len() != 0 {
+		t.Errorf("Wrong body returned:\n%s", w.Body)
+	}
+}
+
+func TestServeHTTPStreamError(t *testing.T) {
+	h, err := sampleTemplateHandler("error")
+	if err != nil {
+		t.Fatalf("Unable to create template for testing: %v", err)
+	}
+	h.Stream = true
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/foo", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Error before the response started not reported: %d", w.Code)
+	}
+}
+
+func TestServeHTTPStreamErrorAfterStart(t *testing.T) {
+	h, err := sampleTemplateHandler("large")
+	if err != nil {
+		t.Fatalf("Unable to create template for testing: %v", err)
+	}
+
+	padding := bytes.Repeat([]byte("x"), 2*streamBufferSize)
+	_, err = h.Template.New("large").Parse(string(padding) + "{{ .NonExistData }}")
+	if err != nil {
+		t.Fatalf("Unable to parse template: %v", err)
+	}
+	h.Stream = true
+
+	w := httptest.NewRecorder()
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Errorf("Expected the connection to be aborted, got: %v", recovered)
+		}
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("Response wasn't started before the error: %d", w.Code)
+		}
+	}()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/foo", nil))
+}
+
+type dataSelectorRenderManager struct {
+	DefaultRenderManager
+}
+
+func (m *dataSelectorRenderManager) TemplateSelectorWithData(r *http.Request, t *template.Template, data interface{}) (string, error) {
+	data.(map[string]string)["selected"] = "test"
+	return "test", nil
+}
+
+func (m *dataSelectorRenderManager) TemplateFuncs() template.FuncMap {
+	return template.FuncMap{}
+}
+
+func TestTemplateRenderingDataSelector(t *testing.T) {
+	tmpl, err := template.New("test").Parse("{{ .selected }}")
+	if err != nil {
+		t.Fatalf("Unable to parse template: %v", err)
+	}
+
+	rm := &dataSelectorRenderManager{DefaultRenderManager{Data: map[string]string{}}}
+	h := &TemplateHandler{Template: tmpl, RenderManager: rm}
+
+	var b bytes.Buffer
+	if err := h.renderTemplate(&b, &http.Request{}); err != nil {
+		t.Fatalf("Error rendering template: %s", err)
+	}
+
+	if b.String() != "test" {
+		t.Errorf("Data added by the template selector not rendered: %s", b.String())
+	}
+}